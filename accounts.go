@@ -0,0 +1,127 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/markbates/goth"
+)
+
+const accountsKey = "login:accounts"
+
+var accountSwitching = false
+
+// account is an identity signed in the browser, kept for switching
+type account struct {
+	Email    string `json:"email"`
+	Provider string `json:"provider"`
+	Name     string `json:"name,omitempty"`
+	Avatar   string `json:"avatar,omitempty"`
+}
+
+// SetAccountSwitching keeps all identities signed in the browser and adds the route which switches
+// between them, e.g. for shared workstations. The route takes "email" of the account and
+// "returnTo" parameters, the user confirms the account at the provider, which usually takes one click.
+// Logout removes only the current account. Accounts of password and email link logins are sent
+// to the login route
+func SetAccountSwitching(r Router, switchURL string) {
+	accountSwitching = true
+
+	addRoute(r, switchURL, labeled("switch", recoverer(func(res http.ResponseWriter, req *http.Request) {
+		email := normalizeEmail(req.URL.Query().Get("email"))
+		if email == normalizeEmail(GetEmail(req)) {
+			target := localPath(req.URL.Query().Get("returnTo"))
+			if target == "" {
+				target = "/"
+			}
+			respond(res, req, target, nil)
+			return
+		}
+
+		var found *account
+		for _, a := range loadAccounts(req) {
+			if normalizeEmail(a.Email) == email {
+				found = &a
+				break
+			}
+		}
+		if found == nil {
+			renderError(res, req, http.StatusNotFound, msgUnknownAccount, nil)
+			return
+		}
+
+		if err := saveReturnTo(res, req); err != nil {
+			reportError(req, err)
+		}
+		if _, err := getProvider(found.Provider); err != nil {
+			respond(res, req, loginRoute, nil)
+			return
+		}
+		BeginAuthHandler(res, WithAuthParams(req, url.Values{"login_hint": {found.Email}}), found.Provider)
+	})))
+}
+
+// Accounts returns identities signed in the browser, the current one included,
+// only email, provider, name and avatar are filled
+func Accounts(req *http.Request) []goth.User {
+	list := loadAccounts(req)
+	users := make([]goth.User, 0, len(list))
+	for _, a := range list {
+		users = append(users, goth.User{Email: a.Email, Provider: a.Provider, Name: a.Name, AvatarURL: a.Avatar})
+	}
+	return users
+}
+
+func loadAccounts(req *http.Request) []account {
+	raw, err := loadSession(req).GetString(accountsKey)
+	if err != nil || raw == "" {
+		return nil
+	}
+	var list []account
+	if err := json.Unmarshal([]byte(raw), &list); err != nil {
+		logger.Errorf("%sCan't read accounts of the session, %s", logPrefix(req), err.Error())
+		return nil
+	}
+	return list
+}
+
+func saveAccounts(res http.ResponseWriter, req *http.Request, list []account) error {
+	if len(list) == 0 {
+		return loadSession(req).Remove(res, accountsKey)
+	}
+	raw, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return loadSession(req).PutString(res, accountsKey, string(raw))
+}
+
+// addAccount puts the user first in the list of accounts
+func addAccount(res http.ResponseWriter, req *http.Request, user goth.User) error {
+	if !accountSwitching {
+		return nil
+	}
+	list := []account{{Email: user.Email, Provider: user.Provider, Name: user.Name, Avatar: user.AvatarURL}}
+	for _, a := range loadAccounts(req) {
+		if normalizeEmail(a.Email) != normalizeEmail(user.Email) {
+			list = append(list, a)
+		}
+	}
+	return saveAccounts(res, req, list)
+}
+
+// removeAccount drops the current user from the list of accounts
+func removeAccount(res http.ResponseWriter, req *http.Request) error {
+	if !accountSwitching {
+		return nil
+	}
+	email := normalizeEmail(GetEmail(req))
+	var list []account
+	for _, a := range loadAccounts(req) {
+		if normalizeEmail(a.Email) != email {
+			list = append(list, a)
+		}
+	}
+	return saveAccounts(res, req, list)
+}
@@ -0,0 +1,90 @@
+package login
+
+import (
+	"sync"
+	"time"
+)
+
+// Alert describes repeated authentication failures from the same source
+type Alert struct {
+	// Key is "ip" or "email"
+	Key      string
+	Value    string
+	Failures int
+	Window   time.Duration
+	Last     Event
+}
+
+type failureCounter struct {
+	sync.Mutex
+	threshold int
+	window    time.Duration
+	failures  map[string][]time.Time
+	events    int
+}
+
+// alertSweepEvery is the count of failures after which stale sources of all keys are dropped
+const alertSweepEvery = 1000
+
+// AlertOnFailures calls notify when threshold of failed callbacks for the same IP or email
+// is reached within the window. Counter of the source is reset after each notification
+func AlertOnFailures(threshold int, window time.Duration, notify func(Alert)) {
+	c := &failureCounter{
+		threshold: threshold,
+		window:    window,
+		failures:  make(map[string][]time.Time),
+	}
+
+	OnDenied(func(e Event) {
+		if e.IP != "" {
+			if n := c.add("ip:"+e.IP, time.Now()); n > 0 {
+				notify(Alert{Key: "ip", Value: e.IP, Failures: n, Window: window, Last: e})
+			}
+		}
+		if e.Email != "" {
+			if n := c.add("email:"+e.Email, time.Now()); n > 0 {
+				notify(Alert{Key: "email", Value: e.Email, Failures: n, Window: window, Last: e})
+			}
+		}
+	})
+}
+
+// add records a failure and returns count of failures when threshold is reached, or 0
+func (c *failureCounter) add(key string, now time.Time) int {
+	c.Lock()
+	defer c.Unlock()
+
+	// failures outside of the window are dropped for the key, and from time to time
+	// for all keys to keep the map small
+	c.events++
+	if c.events >= alertSweepEvery {
+		c.events = 0
+		for k := range c.failures {
+			c.prune(k, now)
+		}
+	}
+	c.prune(key, now)
+
+	times := append(c.failures[key], now)
+	if len(times) >= c.threshold {
+		delete(c.failures, key)
+		return len(times)
+	}
+
+	c.failures[key] = times
+	return 0
+}
+
+// prune drops failures of the key outside of the window
+func (c *failureCounter) prune(key string, now time.Time) {
+	times := c.failures[key]
+	i := 0
+	for i < len(times) && now.Sub(times[i]) > c.window {
+		i++
+	}
+	if i == len(times) {
+		delete(c.failures, key)
+	} else {
+		c.failures[key] = times[i:]
+	}
+}
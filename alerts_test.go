@@ -0,0 +1,27 @@
+package login
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFailureCounterPrune(t *testing.T) {
+	c := &failureCounter{threshold: 3, window: time.Minute, failures: make(map[string][]time.Time)}
+	start := time.Now()
+
+	c.add("ip:1", start)
+	c.add("ip:1", start.Add(30*time.Second))
+	if n := c.add("ip:1", start.Add(2*time.Minute)); n != 0 {
+		t.Errorf("failures outside of the window are counted, %d", n)
+	}
+
+	// stale sources are swept after enough events
+	later := start.Add(time.Hour)
+	for i := 0; i < alertSweepEvery; i++ {
+		c.add("email:"+strconv.Itoa(i%2), later)
+	}
+	if _, ok := c.failures["ip:1"]; ok {
+		t.Error("stale source is kept")
+	}
+}
@@ -0,0 +1,82 @@
+package login
+
+import (
+	"sync"
+	"time"
+)
+
+// AllowCache keeps results of an access check for a while, so checks backed by a database
+// or an external service are not called on each request
+type AllowCache struct {
+	allow func(email string) bool
+	ttl   time.Duration
+	size  int
+
+	mu      sync.Mutex
+	results map[string]allowResult
+}
+
+type allowResult struct {
+	allowed bool
+	expires time.Time
+}
+
+// CacheAllow wraps the check for Protect, results are kept for ttl and at most size users are cached
+func CacheAllow(allow func(email string) bool, ttl time.Duration, size int) *AllowCache {
+	return &AllowCache{allow: allow, ttl: ttl, size: size, results: make(map[string]allowResult)}
+}
+
+// Allow returns cached result of the check, it can be passed to Protect
+func (c *AllowCache) Allow(email string) bool {
+	key := normalizeEmail(email)
+	now := clock()
+
+	c.mu.Lock()
+	r, ok := c.results[key]
+	c.mu.Unlock()
+	if ok && now.Before(r.expires) {
+		return r.allowed
+	}
+
+	// the check is called without the lock, concurrent misses may call it twice
+	allowed := c.allow(email)
+
+	c.mu.Lock()
+	if len(c.results) >= c.size {
+		c.evict(now)
+	}
+	c.results[key] = allowResult{allowed: allowed, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return allowed
+}
+
+// Invalidate removes cached result of the user, call it when access of the user is changed
+func (c *AllowCache) Invalidate(email string) {
+	c.mu.Lock()
+	delete(c.results, normalizeEmail(email))
+	c.mu.Unlock()
+}
+
+// Reset removes all cached results
+func (c *AllowCache) Reset() {
+	c.mu.Lock()
+	c.results = make(map[string]allowResult)
+	c.mu.Unlock()
+}
+
+// evict removes expired results, or the one closest to expiration when all are fresh
+func (c *AllowCache) evict(now time.Time) {
+	oldest := ""
+	for key, r := range c.results {
+		if !now.Before(r.expires) {
+			delete(c.results, key)
+			continue
+		}
+		if oldest == "" || r.expires.Before(c.results[oldest].expires) {
+			oldest = key
+		}
+	}
+	if len(c.results) >= c.size && oldest != "" {
+		delete(c.results, oldest)
+	}
+}
@@ -0,0 +1,30 @@
+package login
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllowCacheClock(t *testing.T) {
+	now := time.Now()
+	SetClock(func() time.Time { return now })
+	defer SetClock(nil)
+
+	calls := 0
+	cache := CacheAllow(func(email string) bool {
+		calls++
+		return true
+	}, time.Minute, 10)
+
+	cache.Allow("user@example.com")
+	now = now.Add(30 * time.Second)
+	cache.Allow("user@example.com")
+	if calls != 1 {
+		t.Errorf("check is called %d times within ttl", calls)
+	}
+	now = now.Add(time.Minute)
+	cache.Allow("user@example.com")
+	if calls != 2 {
+		t.Errorf("check is called %d times after ttl", calls)
+	}
+}
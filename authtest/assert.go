@@ -0,0 +1,65 @@
+package authtest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// AssertDenied checks that the response is the denied page or its JSON variant
+func AssertDenied(t testing.TB, res *http.Response) {
+	t.Helper()
+
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("authtest: expected access denied, got %d", res.StatusCode)
+	}
+}
+
+// AssertUnauthenticated checks that the response redirects to the login route or is 401 for api calls
+func AssertUnauthenticated(t testing.TB, res *http.Response) {
+	t.Helper()
+
+	if res.StatusCode == http.StatusUnauthorized {
+		return
+	}
+	if res.StatusCode != http.StatusTemporaryRedirect || res.Header.Get("Location") == "" {
+		t.Errorf("authtest: expected redirect to login, got %d", res.StatusCode)
+	}
+}
+
+// AssertAccess serves request of the user with the email and checks that the handler
+// neither redirects to the login route nor denies access. Empty email sends anonymous request
+func AssertAccess(t testing.TB, handler http.Handler, method, target, email string) *http.Response {
+	t.Helper()
+
+	res := serve(handler, method, target, email)
+	if res.StatusCode == http.StatusForbidden || res.StatusCode == http.StatusUnauthorized ||
+		res.StatusCode == http.StatusTemporaryRedirect {
+		t.Errorf("authtest: %s %s is not accessible for %q, got %d", method, target, email, res.StatusCode)
+	}
+	return res
+}
+
+// AssertNoAccess serves request of the user with the email and checks that access is denied,
+// anonymous request is expected to be unauthenticated
+func AssertNoAccess(t testing.TB, handler http.Handler, method, target, email string) *http.Response {
+	t.Helper()
+
+	res := serve(handler, method, target, email)
+	if email == "" {
+		AssertUnauthenticated(t, res)
+	} else {
+		AssertDenied(t, res)
+	}
+	return res
+}
+
+func serve(handler http.Handler, method, target, email string) *http.Response {
+	req := httptest.NewRequest(method, target, nil)
+	if email != "" {
+		req = NewRequest(method, target, email)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Result()
+}
@@ -0,0 +1,101 @@
+package authtest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexedwards/scs"
+	"github.com/alexedwards/scs/stores/memstore"
+	"github.com/mkozhukh/login"
+)
+
+// recordingT keeps failures of assertions instead of failing the test
+type recordingT struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func protected() http.Handler {
+	login.SetSession(scs.NewManager(memstore.New(0)))
+	return login.Protect(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(login.GetEmail(req)))
+	}), func(email string) bool { return email == alice.Email })
+}
+
+func TestAssertAccess(t *testing.T) {
+	h := protected()
+
+	AssertAccess(t, h, "GET", "/admin/", alice.Email)
+	AssertNoAccess(t, h, "GET", "/admin/", bob.Email)
+	AssertNoAccess(t, h, "GET", "/admin/", "")
+
+	// wrong expectations are reported
+	r := &recordingT{TB: t}
+	AssertAccess(r, h, "GET", "/admin/", bob.Email)
+	AssertAccess(r, h, "GET", "/admin/", "")
+	AssertNoAccess(r, h, "GET", "/admin/", alice.Email)
+	if len(r.failures) != 3 {
+		t.Errorf("expected 3 failures, got %q", r.failures)
+	}
+}
+
+func TestAssertResponses(t *testing.T) {
+	denied := httptest.NewRecorder()
+	denied.WriteHeader(http.StatusForbidden)
+	redirect := httptest.NewRecorder()
+	redirect.Header().Set("Location", "/login")
+	redirect.WriteHeader(http.StatusTemporaryRedirect)
+	unauthorized := httptest.NewRecorder()
+	unauthorized.WriteHeader(http.StatusUnauthorized)
+
+	AssertDenied(t, denied.Result())
+	AssertUnauthenticated(t, redirect.Result())
+	AssertUnauthenticated(t, unauthorized.Result())
+
+	r := &recordingT{TB: t}
+	AssertDenied(r, redirect.Result())
+	AssertUnauthenticated(r, denied.Result())
+	if len(r.failures) != 2 {
+		t.Errorf("expected 2 failures, got %q", r.failures)
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	h := protected()
+
+	req := NewRequest("GET", "/admin/", alice.Email)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != alice.Email {
+		t.Errorf("request of alice got %d %q", rec.Code, rec.Body.String())
+	}
+
+	// one cookie of each name is returned, the last written one
+	cookies, err := Cookies(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, c := range cookies {
+		if names[c.Name] {
+			t.Errorf("cookie %s is returned twice", c.Name)
+		}
+		names[c.Name] = true
+	}
+
+	req = httptest.NewRequest("GET", "/admin/", nil)
+	if err := SignIn(req, bob); err != nil {
+		t.Fatal(err)
+	}
+	if email := login.GetEmail(req); email != bob.Email {
+		t.Errorf("request is signed in as %q", email)
+	}
+}
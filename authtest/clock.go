@@ -0,0 +1,46 @@
+package authtest
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mkozhukh/login"
+)
+
+// Clock is a manually advanced clock for state tokens and login times of the login package
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock creates clock stopped at the time and sets it as the clock of the login package,
+// call login.SetClock(nil) to restore the system clock
+func NewClock(now time.Time) *Clock {
+	c := &Clock{now: now}
+	login.SetClock(c.Now)
+	return c
+}
+
+// Now returns current time of the clock
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward, e.g. past the max age of state tokens
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// ExpireSession removes session cookies from the client, as the browser does when the session expires
+func (f *Flow) ExpireSession() {
+	var expired []*http.Cookie
+	for _, c := range f.Cookies() {
+		expired = append(expired, &http.Cookie{Name: c.Name, Path: "/", MaxAge: -1})
+	}
+	f.client.Jar.SetCookies(f.app, expired)
+}
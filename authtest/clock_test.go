@@ -0,0 +1,80 @@
+package authtest
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mkozhukh/login"
+)
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+	defer login.SetClock(nil)
+
+	if !clock.Now().Equal(start) {
+		t.Fatalf("clock starts at %s", clock.Now())
+	}
+	clock.Advance(time.Hour)
+	if !clock.Now().Equal(start.Add(time.Hour)) {
+		t.Errorf("clock is advanced to %s", clock.Now())
+	}
+}
+
+func TestClockLoginTime(t *testing.T) {
+	fake, appURL := newApp(t, alice)
+	events := NewRecorder()
+	clock := NewClock(time.Now().Add(-time.Hour))
+	defer login.SetClock(nil)
+
+	flow := NewFlow(t, fake, appURL)
+	loginAt := clock.Now()
+	flow.Login("")
+	clock.Advance(time.Minute)
+	flow.Logout()
+
+	e := events.AssertEvent(t, login.LogoutSuccess, alice.Email)
+	if e.Session == nil || !e.Session.LoginTime.Equal(loginAt) {
+		t.Errorf("login time of the session is %v, expected %s", e.Session, loginAt)
+	}
+}
+
+func TestClockStateExpiry(t *testing.T) {
+	fake, appURL := newApp(t, alice)
+	clock := NewClock(time.Now())
+	defer login.SetClock(nil)
+	login.SetStateSecret([]byte("state secret of the test"), 10*time.Minute)
+	defer login.SetStateSecret(nil, 0)
+	flow := NewFlow(t, fake, appURL)
+
+	// the callback comes after the signed state expired
+	res := flow.Get(flow.LoginPath)
+	res = flow.Get(location(res))
+	clock.Advance(time.Hour)
+	if res = flow.Get(location(res)); isRedirect(res) && location(res) == "/home" {
+		t.Fatal("expired state is accepted")
+	}
+	flow.ExpectUser("")
+
+	// the same flow passes in time
+	clock.Advance(-time.Hour)
+	if target := flow.Login(""); target != "/home" {
+		t.Errorf("login redirected to %s", target)
+	}
+}
+
+func TestExpireSession(t *testing.T) {
+	fake, appURL := newApp(t, alice)
+	flow := NewFlow(t, fake, appURL)
+
+	flow.Login("")
+	flow.ExpectStatus("/app", http.StatusOK)
+
+	flow.ExpireSession()
+	if len(flow.Cookies()) != 0 {
+		t.Errorf("cookies are kept, %v", flow.Cookies())
+	}
+	flow.ExpectUser("")
+	flow.ExpectStatus("/app", http.StatusTemporaryRedirect)
+}
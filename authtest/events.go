@@ -0,0 +1,71 @@
+package authtest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/mkozhukh/login"
+)
+
+// Recorder keeps authentication events in memory,
+// use login.SetEventHandler(rec.Record) or NewRecorder to receive them
+type Recorder struct {
+	mu     sync.Mutex
+	events []login.Event
+}
+
+// NewRecorder creates recorder and sets it as the event handler of the login package
+func NewRecorder() *Recorder {
+	r := &Recorder{}
+	login.SetEventHandler(r.Record)
+	return r
+}
+
+// Record stores the event
+func (r *Recorder) Record(e login.Event) {
+	r.mu.Lock()
+	r.events = append(r.events, e)
+	r.mu.Unlock()
+}
+
+// Events returns all recorded events in order of emission
+func (r *Recorder) Events() []login.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]login.Event(nil), r.events...)
+}
+
+// Find returns events of the type for the email, empty email matches all users
+func (r *Recorder) Find(t login.EventType, email string) []login.Event {
+	var found []login.Event
+	for _, e := range r.Events() {
+		if e.Type == t && (email == "" || e.Email == email) {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// Has checks that an event of the type was emitted for the email
+func (r *Recorder) Has(t login.EventType, email string) bool {
+	return len(r.Find(t, email)) > 0
+}
+
+// Reset removes recorded events
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.events = nil
+	r.mu.Unlock()
+}
+
+// AssertEvent checks that an event of the type was emitted for the email
+func (r *Recorder) AssertEvent(t testing.TB, et login.EventType, email string) login.Event {
+	t.Helper()
+
+	found := r.Find(et, email)
+	if len(found) == 0 {
+		t.Errorf("authtest: no %s event for %q, recorded %d events", et, email, len(r.Events()))
+		return login.Event{}
+	}
+	return found[len(found)-1]
+}
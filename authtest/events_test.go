@@ -0,0 +1,56 @@
+package authtest
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/mkozhukh/login"
+)
+
+func TestRecorder(t *testing.T) {
+	fake, appURL := newApp(t, alice, bob)
+	events := NewRecorder()
+	flow := NewFlow(t, fake, appURL)
+
+	flow.Login("")
+	flow.Logout()
+	e := events.AssertEvent(t, login.LoginSuccess, alice.Email)
+	if e.Provider != "google" || e.IP == "" {
+		t.Errorf("login event is %+v", e)
+	}
+	events.AssertEvent(t, login.LogoutSuccess, alice.Email)
+	if got := events.Events(); len(got) != 2 || got[0].Type != login.LoginSuccess || got[1].Type != login.LogoutSuccess {
+		t.Errorf("events are recorded out of order, %v", got)
+	}
+
+	// the denied login ends with the denied page, so the callback is followed by hand
+	events.Reset()
+	if err := fake.SignInAs(bob.Email); err != nil {
+		t.Fatal(err)
+	}
+	res := flow.Get(flow.LoginPath)
+	res = flow.Get(location(res))
+	if res = flow.Get(location(res)); res.StatusCode != http.StatusForbidden {
+		t.Errorf("denied callback responded with %d", res.StatusCode)
+	}
+	if events.Has(login.LoginSuccess, bob.Email) {
+		t.Error("login denied by the handler is reported as success")
+	}
+	e = events.AssertEvent(t, login.LoginDenied, bob.Email)
+	if !errors.Is(e.Error, login.ErrUserDenied) {
+		t.Errorf("denial has error %v", e.Error)
+	}
+	if !events.Has(login.LoginDenied, "") || events.Has(login.LoginDenied, alice.Email) {
+		t.Error("Has must match the email, empty email matches all users")
+	}
+	if events.Has(login.LogoutSuccess, alice.Email) {
+		t.Error("events before Reset must be dropped")
+	}
+
+	r := &recordingT{TB: t}
+	events.AssertEvent(r, login.LogoutSuccess, bob.Email)
+	if len(r.failures) != 1 {
+		t.Errorf("missing event must be reported, got %q", r.failures)
+	}
+}
@@ -0,0 +1,167 @@
+package authtest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/markbates/goth"
+	"github.com/mkozhukh/login"
+)
+
+// Flow drives a browser-like client through login, protected pages and logout
+// of the application which uses the fake provider. Failed steps stop the test
+type Flow struct {
+	// LoginPath and LogoutPath are routes of the application, "/login" and "/logout" by default
+	LoginPath  string
+	LogoutPath string
+
+	t      testing.TB
+	app    *url.URL
+	client *http.Client
+}
+
+// NewFlow creates client with empty cookie jar, appURL is the base url of the application
+func NewFlow(t testing.TB, server *Server, appURL string) *Flow {
+	t.Helper()
+
+	app, err := url.Parse(appURL)
+	if err != nil {
+		t.Fatalf("authtest: invalid app url %s: %s", appURL, err)
+	}
+	jar, _ := cookiejar.New(nil)
+	client := server.Client()
+	client.Jar = jar
+
+	return &Flow{LoginPath: "/login", LogoutPath: "/logout", t: t, app: app, client: client}
+}
+
+// Login opens the login route with the returnTo path, passes the provider and the callback,
+// and returns the url where the user is redirected after the callback
+func (f *Flow) Login(returnTo string) string {
+	f.t.Helper()
+
+	target := f.LoginPath
+	if returnTo != "" {
+		target += "?returnTo=" + url.QueryEscape(returnTo)
+	}
+
+	res := f.do(target)
+	if u := f.redirect(res); !googleHosts[u.Host] {
+		f.t.Fatalf("authtest: login route redirected to %s instead of the provider", u)
+	}
+
+	res = f.do(location(res))
+	if u := f.redirect(res); u.Host != f.app.Host {
+		f.t.Fatalf("authtest: provider redirected to %s instead of the callback", u)
+	}
+
+	res = f.do(location(res))
+	f.redirect(res)
+	return location(res)
+}
+
+// Get requests the page of the application, redirects are not followed
+func (f *Flow) Get(path string) *http.Response {
+	f.t.Helper()
+	return f.do(path)
+}
+
+// ExpectStatus requests the page and checks status of the response
+func (f *Flow) ExpectStatus(path string, status int) *http.Response {
+	f.t.Helper()
+
+	res := f.do(path)
+	if res.StatusCode != status {
+		f.t.Fatalf("authtest: GET %s responded with %d, expected %d", path, res.StatusCode, status)
+	}
+	return res
+}
+
+// ExpectRedirect requests the page and checks that it redirects to the url
+func (f *Flow) ExpectRedirect(path, target string) {
+	f.t.Helper()
+
+	res := f.do(path)
+	if !isRedirect(res) || location(res) != target {
+		f.t.Fatalf("authtest: GET %s responded with %d %q, expected redirect to %s", path, res.StatusCode, location(res), target)
+	}
+}
+
+// Logout opens the logout route and returns url of the redirect
+func (f *Flow) Logout() string {
+	f.t.Helper()
+
+	res := f.do(f.LogoutPath)
+	if !isRedirect(res) {
+		f.t.Fatalf("authtest: logout route responded with %d", res.StatusCode)
+	}
+	return location(res)
+}
+
+// Cookies returns cookies of the application kept by the client
+func (f *Flow) Cookies() []*http.Cookie {
+	return f.client.Jar.Cookies(f.app)
+}
+
+// User returns the user of the client's session as seen by login.GetUser
+func (f *Flow) User() goth.User {
+	req := httptest.NewRequest(http.MethodGet, f.app.String(), nil)
+	for _, c := range f.Cookies() {
+		req.AddCookie(c)
+	}
+	return login.GetUser(req)
+}
+
+// ExpectUser checks email of the user in the client's session, empty email checks that there is no user
+func (f *Flow) ExpectUser(email string) {
+	f.t.Helper()
+
+	if got := f.User().Email; got != email {
+		f.t.Fatalf("authtest: session contains user %q, expected %q", got, email)
+	}
+}
+
+func (f *Flow) do(target string) *http.Response {
+	f.t.Helper()
+
+	u, err := f.app.Parse(target)
+	if err != nil {
+		f.t.Fatalf("authtest: invalid url %s: %s", target, err)
+	}
+	res, err := f.client.Get(u.String())
+	if err != nil {
+		f.t.Fatalf("authtest: GET %s failed: %s", u, err)
+	}
+	// keep the body readable after the connection is released
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return res
+}
+
+// redirect checks that the response is a redirect and returns its absolute url
+func (f *Flow) redirect(res *http.Response) *url.URL {
+	f.t.Helper()
+
+	if !isRedirect(res) {
+		f.t.Fatalf("authtest: GET %s responded with %d instead of redirect", res.Request.URL, res.StatusCode)
+	}
+	u, err := res.Request.URL.Parse(location(res))
+	if err != nil {
+		f.t.Fatalf("authtest: GET %s redirected to invalid url: %s", res.Request.URL, err)
+	}
+	return u
+}
+
+func isRedirect(res *http.Response) bool {
+	return res.StatusCode >= 300 && res.StatusCode < 400
+}
+
+func location(res *http.Response) string {
+	return res.Header.Get("Location")
+}
@@ -0,0 +1,165 @@
+package authtest
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/markbates/goth"
+	"github.com/mkozhukh/login"
+)
+
+type router struct{ *http.ServeMux }
+
+func (r router) Get(pattern string, fn http.HandlerFunc) { r.HandleFunc(pattern, fn) }
+
+// handler admits everybody except bob
+type handler struct{}
+
+func (handler) Login(req *http.Request, res http.ResponseWriter, email string) string {
+	if email == bob.Email {
+		return ""
+	}
+	return "/home"
+}
+
+func (handler) Logout(req *http.Request, res http.ResponseWriter) string { return "/" }
+
+// newApp starts the application with the fake provider, "/app" is available to alice
+func newApp(t *testing.T, users ...goth.User) (*Server, string) {
+	t.Helper()
+
+	r := router{http.NewServeMux()}
+	app := httptest.NewUnstartedServer(nil)
+	appURL := "http://" + app.Listener.Addr().String()
+
+	fake := NewTestProvider(appURL, r, handler{}, users...)
+	r.Handle("/app", login.Protect(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(login.GetEmail(req)))
+	}), func(email string) bool { return email == alice.Email }))
+
+	app.Config.Handler = fake.Session.Use(r)
+	app.Start()
+	t.Cleanup(func() {
+		app.Close()
+		fake.Close()
+	})
+	return fake, appURL
+}
+
+func sessionCookie(f *Flow) string {
+	for _, c := range f.Cookies() {
+		if c.Name == "session" {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+func TestFlow(t *testing.T) {
+	fake, appURL := newApp(t, alice, bob)
+	flow := NewFlow(t, fake, appURL)
+
+	flow.ExpectRedirect("/app", "/login?returnTo=%2Fapp")
+	before := sessionCookie(flow)
+	if target := flow.Login("/app"); target != "/app" {
+		t.Errorf("login must return to /app, got %s", target)
+	}
+	flow.ExpectUser(alice.Email)
+
+	res := flow.ExpectStatus("/app", http.StatusOK)
+	if body, _ := ioutil.ReadAll(res.Body); string(body) != alice.Email {
+		t.Errorf("/app responded with %q", body)
+	}
+
+	// the token known before the login isn't valid after it, and the same for logout
+	loggedIn := sessionCookie(flow)
+	if loggedIn == "" || loggedIn == before {
+		t.Error("session token must be renewed by the login")
+	}
+	if target := flow.Logout(); target != "/" {
+		t.Errorf("logout must redirect to /, got %s", target)
+	}
+	flow.ExpectUser("")
+	if sessionCookie(flow) == loggedIn {
+		t.Error("session token must be renewed by the logout")
+	}
+	flow.ExpectRedirect("/app", "/login?returnTo=%2Fapp")
+}
+
+func TestFlowProvider(t *testing.T) {
+	fake, appURL := newApp(t, alice, goth.User{Email: "carol@example.com", Name: "Carol"})
+	flow := NewFlow(t, fake, appURL)
+
+	// the first user signs in by default, others are selected by SignInAs
+	flow.Login("")
+	flow.ExpectUser(alice.Email)
+	flow.Logout()
+
+	if err := fake.SignInAs("carol@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if target := flow.Login(""); target != "/home" {
+		t.Errorf("login must redirect to /home, got %s", target)
+	}
+	flow.ExpectUser("carol@example.com")
+	// carol is authenticated, but not allowed
+	flow.ExpectStatus("/app", http.StatusForbidden)
+}
+
+// spanRecorder keeps outcomes of ended spans by name
+type spanRecorder struct {
+	sync.Mutex
+	outcomes map[string][]string
+}
+
+type recordedSpan struct {
+	r       *spanRecorder
+	name    string
+	outcome string
+}
+
+func (r *spanRecorder) Start(ctx context.Context, name string, provider string) (context.Context, login.Span) {
+	return ctx, &recordedSpan{r: r, name: name}
+}
+
+func (s *recordedSpan) SetAttribute(key, value string) {
+	if key == "auth.outcome" {
+		s.outcome = value
+	}
+}
+
+func (s *recordedSpan) End(err error) {
+	s.r.Lock()
+	defer s.r.Unlock()
+	s.r.outcomes[s.name] = append(s.r.outcomes[s.name], s.outcome)
+}
+
+func TestTracerOutcome(t *testing.T) {
+	fake, appURL := newApp(t, alice, bob)
+	spans := &spanRecorder{outcomes: map[string][]string{}}
+	login.SetTracer(spans)
+	defer login.SetTracer(nil)
+
+	flow := NewFlow(t, fake, appURL)
+	flow.Login("")
+	if err := fake.SignInAs(bob.Email); err != nil {
+		t.Fatal(err)
+	}
+	flow.Logout()
+	res := flow.Get(flow.LoginPath)
+	res = flow.Get(location(res))
+	flow.Get(location(res))
+
+	spans.Lock()
+	defer spans.Unlock()
+	if got := spans.outcomes["login.callback"]; len(got) != 2 || got[0] != "success" || got[1] != "denied" {
+		t.Errorf("callback outcomes are %v", got)
+	}
+	if got := spans.outcomes["login.token_exchange"]; len(got) != 2 || got[0] != "success" {
+		t.Errorf("token exchange outcomes are %v", got)
+	}
+}
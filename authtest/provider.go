@@ -0,0 +1,27 @@
+package authtest
+
+import (
+	"github.com/alexedwards/scs"
+	"github.com/alexedwards/scs/stores/memstore"
+	"github.com/markbates/goth"
+	"github.com/mkozhukh/login"
+)
+
+// NewTestProvider sets in-memory session store and adds "/login", "/logout" and "/callback" routes
+// of the fake provider, appURL is the base url of the application. The first user signs in by default,
+// use SignInAs to select another one. The application must be wrapped with Server.Session.Use
+func NewTestProvider(appURL string, r login.Router, handler login.Handler, users ...goth.User) *Server {
+	var first goth.User
+	if len(users) > 0 {
+		first = users[0]
+	}
+	s := NewServer(first)
+	for _, u := range users {
+		s.users[u.Email] = u
+	}
+
+	s.Session = scs.NewManager(memstore.New(0))
+	login.SetSession(s.Session)
+	login.SetProvider(s.Provider(appURL+"/callback"), r, "/login", "/logout", "/callback", handler)
+	return s
+}
@@ -0,0 +1,230 @@
+// Package authtest contains helpers for testing applications which use the login package
+package authtest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/alexedwards/scs"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/google"
+)
+
+// hosts of Google endpoints used by the goth provider
+var googleHosts = map[string]bool{
+	"accounts.google.com": true,
+	"www.googleapis.com":  true,
+}
+
+// Server imitates authorize, token and userinfo endpoints of Google,
+// the authorize endpoint signs in the current user without any page
+type Server struct {
+	*httptest.Server
+
+	// Session is the in-memory session manager set by NewTestProvider,
+	// wrap the application with Session.Use
+	Session *scs.Manager
+
+	mu     sync.Mutex
+	user   goth.User
+	users  map[string]goth.User
+	codes  map[string]goth.User
+	tokens map[string]goth.User
+	// scopes requested with the code, all of them are granted
+	scopes map[string]string
+	// refresh tokens issued by the server
+	refresh map[string]goth.User
+}
+
+// NewServer starts the fake provider, user is signed in by the authorize endpoint
+func NewServer(user goth.User) *Server {
+	s := &Server{
+		user:    user,
+		users:   map[string]goth.User{user.Email: user},
+		codes:   make(map[string]goth.User),
+		tokens:  make(map[string]goth.User),
+		scopes:  make(map[string]string),
+		refresh: make(map[string]goth.User),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/o/oauth2/auth", s.authorize)
+	mux.HandleFunc("/o/oauth2/token", s.token)
+	mux.HandleFunc("/oauth2/v2/userinfo", s.userinfo)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// SignIn changes the user signed in by the following authorizations,
+// a user with empty email declines the consent
+func (s *Server) SignIn(user goth.User) {
+	s.mu.Lock()
+	s.user = user
+	if user.Email != "" {
+		s.users[user.Email] = user
+	}
+	s.mu.Unlock()
+}
+
+// SignInAs changes the user signed in by the following authorizations to the known user with the email,
+// users are known after passing them to NewServer, NewTestProvider or SignIn
+func (s *Server) SignInAs(email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[email]
+	if !ok {
+		return fmt.Errorf("authtest: unknown user %s", email)
+	}
+	s.user = user
+	return nil
+}
+
+// Client returns http client which sends requests for Google endpoints to the server,
+// redirects are not followed
+func (s *Server) Client() *http.Client {
+	return &http.Client{
+		Transport: s.Transport(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Transport returns round tripper which sends requests for Google endpoints to the server
+func (s *Server) Transport() http.RoundTripper {
+	target, _ := url.Parse(s.URL)
+	return roundTripper(func(req *http.Request) (*http.Response, error) {
+		if googleHosts[req.URL.Host] {
+			req = req.Clone(req.Context())
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.Host = target.Host
+		}
+		return http.DefaultTransport.RoundTrip(req)
+	})
+}
+
+// Provider returns the google provider which uses the server
+func (s *Server) Provider(callbackURL string) goth.Provider {
+	p := google.New("test-key", "test-secret", callbackURL, "email")
+	p.HTTPClient = &http.Client{Transport: s.Transport()}
+	return p
+}
+
+type roundTripper func(req *http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func (s *Server) authorize(res http.ResponseWriter, req *http.Request) {
+	callback, err := url.Parse(req.URL.Query().Get("redirect_uri"))
+	if err != nil || callback.Host == "" {
+		http.Error(res, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	user := s.user
+	query := callback.Query()
+	if user.Email == "" {
+		query.Set("error", "access_denied")
+	} else {
+		code := randomString()
+		s.codes[code] = user
+		s.scopes[code] = req.URL.Query().Get("scope")
+		query.Set("code", code)
+	}
+	s.mu.Unlock()
+
+	query.Set("state", req.URL.Query().Get("state"))
+	callback.RawQuery = query.Encode()
+	http.Redirect(res, req, callback.String(), http.StatusFound)
+}
+
+func (s *Server) token(res http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	var user goth.User
+	var ok bool
+	var scope string
+	if req.PostForm.Get("grant_type") == "refresh_token" {
+		user, ok = s.refresh[req.PostForm.Get("refresh_token")]
+	} else {
+		code := req.PostForm.Get("code")
+		user, ok = s.codes[code]
+		scope = s.scopes[code]
+		delete(s.codes, code)
+		delete(s.scopes, code)
+	}
+	token := randomString()
+	refresh := randomString()
+	if ok {
+		s.tokens[token] = user
+		s.refresh[refresh] = user
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeJSON(res, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	response := map[string]interface{}{
+		"access_token":  token,
+		"refresh_token": refresh,
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+	}
+	if scope != "" {
+		response["scope"] = scope
+	}
+	writeJSON(res, http.StatusOK, response)
+}
+
+func (s *Server) userinfo(res http.ResponseWriter, req *http.Request) {
+	token := req.URL.Query().Get("access_token")
+	if token == "" {
+		token = strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	}
+
+	s.mu.Lock()
+	user, ok := s.tokens[token]
+	s.mu.Unlock()
+
+	if !ok {
+		writeJSON(res, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+	writeJSON(res, http.StatusOK, map[string]string{
+		"id":          user.UserID,
+		"email":       user.Email,
+		"name":        user.Name,
+		"given_name":  user.FirstName,
+		"family_name": user.LastName,
+		"picture":     user.AvatarURL,
+	})
+}
+
+func writeJSON(res http.ResponseWriter, status int, body interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	_ = json.NewEncoder(res).Encode(body)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,142 @@
+package authtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/markbates/goth"
+)
+
+var (
+	alice = goth.User{Email: "alice@example.com", Name: "Alice", UserID: "1"}
+	bob   = goth.User{Email: "bob@example.com", Name: "Bob", UserID: "2"}
+)
+
+// authorize opens the authorize endpoint as the provider does after redirect from the login route
+func authorize(t *testing.T, s *Server) url.Values {
+	t.Helper()
+
+	res, err := s.Client().Get("https://accounts.google.com/o/oauth2/auth?" + url.Values{
+		"redirect_uri": {"http://app.test/callback"},
+		"state":        {"state-1"},
+	}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize responded with %d", res.StatusCode)
+	}
+	callback, _ := url.Parse(res.Header.Get("Location"))
+	if callback.Host != "app.test" || callback.Query().Get("state") != "state-1" {
+		t.Fatalf("authorize redirected to %s", callback)
+	}
+	return callback.Query()
+}
+
+func exchange(t *testing.T, s *Server, form url.Values) (int, map[string]interface{}) {
+	t.Helper()
+
+	res, err := s.Client().PostForm("https://accounts.google.com/o/oauth2/token", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var body map[string]interface{}
+	json.NewDecoder(res.Body).Decode(&body)
+	return res.StatusCode, body
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer(alice)
+	defer s.Close()
+
+	code := authorize(t, s).Get("code")
+	status, token := exchange(t, s, url.Values{"grant_type": {"authorization_code"}, "code": {code}})
+	if status != http.StatusOK || token["access_token"] == "" || token["refresh_token"] == "" {
+		t.Fatalf("code exchange responded with %d %v", status, token)
+	}
+	if status, _ := exchange(t, s, url.Values{"grant_type": {"authorization_code"}, "code": {code}}); status != http.StatusBadRequest {
+		t.Errorf("code is exchanged twice, got %d", status)
+	}
+
+	res, err := s.Client().Get("https://www.googleapis.com/oauth2/v2/userinfo?access_token=" + token["access_token"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info map[string]string
+	json.NewDecoder(res.Body).Decode(&info)
+	res.Body.Close()
+	if info["email"] != alice.Email || info["name"] != alice.Name || info["id"] != alice.UserID {
+		t.Errorf("userinfo returned %v", info)
+	}
+
+	status, refreshed := exchange(t, s, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {token["refresh_token"].(string)}})
+	if status != http.StatusOK || refreshed["access_token"] == token["access_token"] {
+		t.Errorf("refresh responded with %d %v", status, refreshed)
+	}
+}
+
+func TestServerSignIn(t *testing.T) {
+	s := NewServer(alice)
+	defer s.Close()
+
+	s.SignIn(goth.User{})
+	if query := authorize(t, s); query.Get("error") != "access_denied" || query.Get("code") != "" {
+		t.Errorf("user without email must decline the consent, got %v", query)
+	}
+
+	s.SignIn(bob)
+	if err := s.SignInAs(alice.Email); err != nil {
+		t.Errorf("alice is known, got %s", err)
+	}
+	if err := s.SignInAs("carol@example.com"); err == nil || !strings.Contains(err.Error(), "unknown user") {
+		t.Errorf("unknown user must be rejected, got %v", err)
+	}
+}
+
+func TestServerTransport(t *testing.T) {
+	s := NewServer(alice)
+	defer s.Close()
+
+	other := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("other"))
+	}))
+	defer other.Close()
+
+	// hosts other than Google are not redirected
+	res, err := s.Client().Get(other.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("request to another host responded with %d", res.StatusCode)
+	}
+}
+
+func TestServerProvider(t *testing.T) {
+	s := NewServer(alice)
+	defer s.Close()
+
+	p := s.Provider("http://app.test/callback")
+	session, err := p.BeginAuth("state-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := authorize(t, s).Get("code")
+	if _, err := session.Authorize(p, url.Values{"code": {code}}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := p.FetchUser(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != alice.Email || user.AccessToken == "" {
+		t.Errorf("provider fetched %+v", user)
+	}
+}
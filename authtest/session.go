@@ -0,0 +1,53 @@
+package authtest
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/markbates/goth"
+	"github.com/mkozhukh/login"
+)
+
+// Cookies returns session cookies of the authenticated user,
+// the session store must be set by login.SetSession
+func Cookies(user goth.User) ([]*http.Cookie, error) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := login.SignIn(rec, req, user); err != nil {
+		return nil, err
+	}
+
+	// the session cookie is written on each change, only the last one is valid
+	var cookies []*http.Cookie
+	index := map[string]int{}
+	for _, c := range rec.Result().Cookies() {
+		if i, ok := index[c.Name]; ok {
+			cookies[i] = c
+			continue
+		}
+		index[c.Name] = len(cookies)
+		cookies = append(cookies, c)
+	}
+	return cookies, nil
+}
+
+// SignIn adds session cookies of the user to the request
+func SignIn(req *http.Request, user goth.User) error {
+	cookies, err := Cookies(user)
+	if err != nil {
+		return err
+	}
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	return nil
+}
+
+// NewRequest returns request of the user with the email, like httptest.NewRequest it panics on error
+func NewRequest(method, target, email string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	if err := SignIn(req, goth.User{Email: email}); err != nil {
+		panic("authtest: " + err.Error())
+	}
+	return req
+}
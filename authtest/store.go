@@ -0,0 +1,88 @@
+package authtest
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs"
+)
+
+// TestStore validates scs.Store implementation against the contract used by the login package,
+// e.g. custom stores passed to SetUsedStateStore or the session manager.
+// newStore must return an empty store for each call
+func TestStore(t *testing.T, newStore func() scs.Store) {
+	t.Run("save and find", func(t *testing.T) {
+		s := newStore()
+		if err := s.Save("token", []byte("value"), time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("save failed: %s", err)
+		}
+		b, found, err := s.Find("token")
+		if err != nil || !found || !bytes.Equal(b, []byte("value")) {
+			t.Errorf("saved value not found, got %q %v %v", b, found, err)
+		}
+	})
+
+	t.Run("missing token", func(t *testing.T) {
+		s := newStore()
+		if _, found, err := s.Find("missing"); found || err != nil {
+			t.Errorf("missing token must not be found without error, got %v %v", found, err)
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		s := newStore()
+		_ = s.Save("token", []byte("first"), time.Now().Add(time.Minute))
+		_ = s.Save("token", []byte("second"), time.Now().Add(time.Minute))
+		if b, _, _ := s.Find("token"); !bytes.Equal(b, []byte("second")) {
+			t.Errorf("value must be replaced, got %q", b)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		s := newStore()
+		// stores may keep expiry with seconds precision
+		_ = s.Save("token", []byte("value"), time.Now().Add(time.Second))
+		time.Sleep(2 * time.Second)
+		if _, found, _ := s.Find("token"); found {
+			t.Error("expired token must not be found")
+		}
+	})
+
+	t.Run("revocation", func(t *testing.T) {
+		s := newStore()
+		_ = s.Save("token", []byte("value"), time.Now().Add(time.Minute))
+		if err := s.Delete("token"); err != nil {
+			t.Fatalf("delete failed: %s", err)
+		}
+		if _, found, _ := s.Find("token"); found {
+			t.Error("deleted token must not be found")
+		}
+		if err := s.Delete("token"); err != nil {
+			t.Errorf("delete of missing token must not fail, got %s", err)
+		}
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		s := newStore()
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				key := fmt.Sprintf("token-%d", i)
+				value := []byte(key)
+				if err := s.Save(key, value, time.Now().Add(time.Minute)); err != nil {
+					t.Errorf("save of %s failed: %s", key, err)
+					return
+				}
+				if b, found, err := s.Find(key); err != nil || !found || !bytes.Equal(b, value) {
+					t.Errorf("concurrent value %s not found, got %q %v %v", key, b, found, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+}
@@ -0,0 +1,14 @@
+package authtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs"
+	"github.com/alexedwards/scs/stores/memstore"
+)
+
+// the default store of used states passes the suite
+func TestMemstore(t *testing.T) {
+	TestStore(t, func() scs.Store { return memstore.New(time.Minute) })
+}
@@ -0,0 +1,227 @@
+package login
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const captchaMarker = "login_captcha"
+
+// secretFields are not echoed by the captcha page, the user types them again
+var secretFields = []string{"password"}
+
+// Captcha is a challenge shown to clients which exceed the rate of login attempts
+type Captcha interface {
+	// Widget returns html of the challenge placed in the form, scripts must carry the nonce
+	Widget(nonce string) template.HTML
+	// Verify checks the answer posted with the form
+	Verify(req *http.Request) error
+}
+
+// siteVerify is a captcha with a siteverify endpoint, reCAPTCHA and hCaptcha share the protocol
+type siteVerify struct {
+	siteKey, secret string
+	script, class   string
+	field, endpoint string
+}
+
+// ReCaptcha returns the reCAPTCHA v2 checkbox challenge
+func ReCaptcha(siteKey, secret string) Captcha {
+	return &siteVerify{
+		siteKey: siteKey, secret: secret,
+		script: "https://www.google.com/recaptcha/api.js", class: "g-recaptcha",
+		field: "g-recaptcha-response", endpoint: "https://www.google.com/recaptcha/api/siteverify",
+	}
+}
+
+// HCaptcha returns the hCaptcha challenge
+func HCaptcha(siteKey, secret string) Captcha {
+	return &siteVerify{
+		siteKey: siteKey, secret: secret,
+		script: "https://js.hcaptcha.com/1/api.js", class: "h-captcha",
+		field: "h-captcha-response", endpoint: "https://hcaptcha.com/siteverify",
+	}
+}
+
+func (c *siteVerify) Widget(nonce string) template.HTML {
+	return template.HTML(fmt.Sprintf(`<script src="%s" nonce="%s" async defer></script><div class="%s" data-sitekey="%s"></div>`,
+		template.HTMLEscapeString(c.script), template.HTMLEscapeString(nonce), c.class, template.HTMLEscapeString(c.siteKey)))
+}
+
+func (c *siteVerify) Verify(req *http.Request) error {
+	answer := req.FormValue(c.field)
+	if answer == "" {
+		return errors.New("captcha is not solved")
+	}
+
+	client := httpClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	res, err := client.PostForm(c.endpoint, url.Values{
+		"secret":   {c.secret},
+		"response": {answer},
+		"remoteip": {clientIP(req)},
+	})
+	if err != nil {
+		return fmt.Errorf("can't verify captcha: %w", err)
+	}
+	defer res.Body.Close()
+
+	var result struct {
+		Success bool     `json:"success"`
+		Errors  []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return fmt.Errorf("can't verify captcha: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("captcha is rejected: %v", result.Errors)
+	}
+	return nil
+}
+
+var captcha Captcha
+var attempts = &attemptCounter{window: time.Minute}
+
+// SetCaptcha enables the challenge for clients which make more than limit login attempts
+// from one IP in the window. Clients behind a shared NAT solve it instead of being blocked,
+// each attempt over the limit needs its own solved challenge. Nil disables the challenge
+func SetCaptcha(c Captcha, limit int, window time.Duration) {
+	captcha = c
+	attempts = &attemptCounter{limit: limit, window: window}
+}
+
+// CaptchaInfo is passed to the captcha page template
+type CaptchaInfo struct {
+	Method string
+	Action string
+	Fields url.Values
+	// Secrets are names of fields, e.g. the password, which the user enters again
+	Secrets []string
+	Marker  string
+	Widget  template.HTML
+	Nonce   string
+	T       Messages
+}
+
+var captchaPage = template.Must(template.New("captcha").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.T.captcha_title}}</title></head>
+<body>
+<h1>{{.T.captcha_title}}</h1>
+<p>{{.T.captcha_text}}</p>
+<form method="{{.Method}}" action="{{.Action}}">
+{{range $name, $values := .Fields}}{{range $values}}<input type="hidden" name="{{$name}}" value="{{.}}">
+{{end}}{{end}}{{range .Secrets}}<input type="password" name="{{.}}" placeholder="{{$.T.captcha_password}}" required>
+{{end}}<input type="hidden" name="{{.Marker}}" value="1">
+{{.Widget}}
+<button type="submit">{{.T.captcha_submit}}</button>
+</form>
+</body>
+</html>`))
+
+// captchaPolicy allows scripts and frames of the captcha services
+const captchaPolicy = "default-src 'none'; script-src 'nonce-{nonce}' 'strict-dynamic' https:; frame-src https:; connect-src https:; style-src 'unsafe-inline' https:; img-src https: data:; form-action 'self'; frame-ancestors 'none'; base-uri 'none'"
+
+// SetCaptchaPage defines template of the challenge page
+func SetCaptchaPage(tmpl *template.Template) {
+	captchaPage = tmpl
+}
+
+// challenged asks clients which exceed the rate of attempts to solve the captcha before the handler,
+// the answer is verified for each attempt, so one solved challenge doesn't open the window
+func challenged(next http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if captcha == nil || !attempts.exceeded(clientIP(req)) {
+			next(res, req)
+			return
+		}
+
+		if req.FormValue(captchaMarker) != "" {
+			err := captcha.Verify(req)
+			if err == nil {
+				next(res, req)
+				return
+			}
+			debugf(req, "captcha failed, %s", err.Error())
+		}
+
+		if jsonMode || wantsJSON(req) {
+			writeJSON(res, http.StatusTooManyRequests, map[string]interface{}{
+				"status": "captcha_required",
+			})
+			return
+		}
+
+		fields := url.Values{}
+		var secrets []string
+		for name, values := range req.Form {
+			switch {
+			case isSecretField(name):
+				secrets = append(secrets, name)
+			case name != captchaMarker && name != "g-recaptcha-response" && name != "h-captcha-response":
+				fields[name] = values
+			}
+		}
+		sort.Strings(secrets)
+		res.Header().Set("Cache-Control", "no-store")
+		renderPageWithPolicy(res, http.StatusTooManyRequests, captchaPage, captchaPolicy, func(nonce string) interface{} {
+			return CaptchaInfo{
+				Method: req.Method, Action: req.URL.Path, Fields: fields, Secrets: secrets, Marker: captchaMarker,
+				Widget: captcha.Widget(nonce), Nonce: nonce, T: messagesFor(req),
+			}
+		})
+	}
+}
+
+func isSecretField(name string) bool {
+	for _, f := range secretFields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// attemptCounter counts login attempts of each IP in fixed windows, counts are kept in the store
+// of used states, so SetMultiInstance shares them between instances
+type attemptCounter struct {
+	limit  int
+	window time.Duration
+
+	mu sync.Mutex
+}
+
+// exceeded registers the attempt and checks the limit, the attempt is allowed when the store fails
+func (c *attemptCounter) exceeded(ip string) bool {
+	now := clock()
+	start := now.Truncate(c.window)
+	key := "login-attempts:" + ip + ":" + strconv.FormatInt(start.Unix(), 10)
+
+	// increments of other instances at the same moment can be lost, the limit is approximate
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count := 0
+	data, found, err := usedStates.Find(key)
+	if err == nil && found {
+		count, _ = strconv.Atoi(string(data))
+	}
+	if err == nil {
+		count++
+		err = usedStates.Save(key, []byte(strconv.Itoa(count)), start.Add(c.window+clockSkew))
+	}
+	if err != nil {
+		logger.Errorf("Can't count login attempts, %s", err.Error())
+		return false
+	}
+	return count > c.limit
+}
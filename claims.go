@@ -0,0 +1,203 @@
+package login
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/markbates/goth"
+)
+
+const levelKey = "login:level"
+
+// ClaimRule gives access level to users whose claim has the value
+type ClaimRule struct {
+	// Claim is a dotted path in claims of the provider, e.g. "hd" or "groups"
+	Claim string `json:"claim" yaml:"claim"`
+	// Value is compared with the claim or with each item of a list claim, "*" matches any value
+	Value string `json:"value" yaml:"value"`
+	Level string `json:"level" yaml:"level"`
+}
+
+// ClaimMapping is a list of rules, the first matching rule defines the level
+type ClaimMapping []ClaimRule
+
+// ParseClaimMapping reads rules in form of "claim = value -> level", one per line,
+// lines starting with # are ignored
+//
+//	hd = example.com -> user
+//	groups = admins@example.com -> admin
+func ParseClaimMapping(text string) (ClaimMapping, error) {
+	var mapping ClaimMapping
+	for n, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		arrow := strings.LastIndex(line, "->")
+		eq := strings.Index(line, "=")
+		if arrow < 0 || eq < 0 || eq > arrow {
+			return nil, fmt.Errorf("line %d: expected \"claim = value -> level\"", n+1)
+		}
+		rule := ClaimRule{
+			Claim: strings.TrimSpace(line[:eq]),
+			Value: strings.TrimSpace(line[eq+1 : arrow]),
+			Level: strings.TrimSpace(line[arrow+2:]),
+		}
+		if rule.Claim == "" || rule.Value == "" || rule.Level == "" {
+			return nil, fmt.Errorf("line %d: claim, value and level must not be empty", n+1)
+		}
+		mapping = append(mapping, rule)
+	}
+	return mapping, nil
+}
+
+// String formats the rule as a line of ParseClaimMapping
+func (r ClaimRule) String() string {
+	return r.Claim + " = " + r.Value + " -> " + r.Level
+}
+
+// String formats the rules, so they round-trip through ParseClaimMapping, logs and config
+func (m ClaimMapping) String() string {
+	lines := make([]string, len(m))
+	for i, rule := range m {
+		lines[i] = rule.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Level returns level of the user by claims in RawData, or an empty string when no rule matches
+func (m ClaimMapping) Level(user goth.User) string {
+	return m.Explain(user).Level
+}
+
+// LevelExplanation tells which rule gives the level, e.g. to troubleshoot "I should have access" tickets
+type LevelExplanation struct {
+	Level string `json:"level"`
+	// Index of the matching rule, -1 when no rule matches
+	Index int        `json:"index"`
+	Rule  *ClaimRule `json:"rule,omitempty"`
+	// Claim is the value of the user's claim checked by the rule
+	Claim interface{} `json:"claim,omitempty"`
+}
+
+// Explain returns the first rule matching claims of the user
+func (m ClaimMapping) Explain(user goth.User) LevelExplanation {
+	for i, rule := range m {
+		value := claimValue(user.RawData, rule.Claim)
+		if matchClaim(value, rule.Value) {
+			return LevelExplanation{Level: rule.Level, Index: i, Rule: &m[i], Claim: value}
+		}
+	}
+	return LevelExplanation{Index: -1}
+}
+
+// ExplainLevel explains the level given to the user by the mapping of SetClaimMapping
+func ExplainLevel(user goth.User) LevelExplanation {
+	return claimMapping.Explain(user)
+}
+
+var claimMapping ClaimMapping
+var claimDeny bool
+var claimHookOnce sync.Once
+
+// SetClaimMapping defines rules which give access levels to users by claims of the provider,
+// so access can be managed with attributes of the identity provider. The level is saved
+// in the session at login and returned by Level. With deny, users matched by no rule can't log in.
+// A later call replaces both the rules and deny
+func SetClaimMapping(m ClaimMapping, deny bool) {
+	claimMapping, claimDeny = m, deny
+	claimHookOnce.Do(func() { BeforeLogin(denyUnmapped) })
+}
+
+// denyUnmapped is the BeforeLogin hook which checks users with the current mapping
+func denyUnmapped(e Event) error {
+	if claimDeny && claimMapping.Level(e.User) == "" {
+		return errors.New("no claim mapping rule matches the user")
+	}
+	return nil
+}
+
+// Level returns access level of the session user, given by SetClaimMapping or elevated by SetSudo
+func Level(req *http.Request) string {
+	level := savedLevel(req)
+	if sudo != nil && level == sudo.From && !SudoUntil(req).IsZero() {
+		return sudo.To
+	}
+	return level
+}
+
+func savedLevel(req *http.Request) string {
+	level, _ := loadSession(req).GetString(levelKey)
+	return level
+}
+
+// RequireLevel wraps the handler, so it is available to authenticated users with one of the levels
+func RequireLevel(next http.Handler, levels ...string) http.Handler {
+	return RequireAuthenticated(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		level := Level(req)
+		for _, l := range levels {
+			if l == level {
+				next.ServeHTTP(res, req)
+				return
+			}
+		}
+		authMetrics.observeDenial("level", "forbidden")
+		if wantsJSON(req) {
+			writeJSON(res, http.StatusForbidden, map[string]interface{}{
+				"status": "denied",
+				"email":  GetEmail(req),
+			})
+			return
+		}
+		renderDenied(res, req, GetEmail(req))
+	}))
+}
+
+func saveLevel(res http.ResponseWriter, req *http.Request, user goth.User) error {
+	session := loadSession(req)
+	level := claimMapping.Level(user)
+	if level == "" {
+		return session.Remove(res, levelKey)
+	}
+	return session.PutString(res, levelKey, level)
+}
+
+// claimValue follows the dotted path in nested objects
+func claimValue(data map[string]interface{}, path string) interface{} {
+	var value interface{} = data
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = obj[part]
+	}
+	return value
+}
+
+func matchClaim(value interface{}, expected string) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case []interface{}:
+		for _, item := range v {
+			if matchClaim(item, expected) {
+				return true
+			}
+		}
+		return false
+	case []string:
+		for _, item := range v {
+			if matchClaim(item, expected) {
+				return true
+			}
+		}
+		return false
+	default:
+		return expected == "*" || fmt.Sprint(v) == expected
+	}
+}
@@ -0,0 +1,60 @@
+package login
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/markbates/goth"
+)
+
+func TestSetClaimMappingTwice(t *testing.T) {
+	defer SetClaimMapping(nil, false)
+
+	mapping := ClaimMapping{{Claim: "hd", Value: "example.com", Level: "user"}}
+	SetClaimMapping(mapping, true)
+	hooks := len(vetoHooks)
+	SetClaimMapping(mapping, true)
+	if len(vetoHooks) != hooks {
+		t.Errorf("second call adds %d hooks", len(vetoHooks)-hooks)
+	}
+
+	user := goth.User{Email: "user@other.com", RawData: map[string]interface{}{"hd": "other.com"}}
+	req := httptest.NewRequest("GET", "/callback", nil)
+	if approveLogin(httptest.NewRecorder(), req, "google", user) {
+		t.Error("user matched by no rule is approved")
+	}
+	SetClaimMapping(mapping, false)
+	if !approveLogin(httptest.NewRecorder(), req, "google", user) {
+		t.Error("deny of the previous call still applies")
+	}
+}
+
+func TestExplainLevel(t *testing.T) {
+	mapping := ClaimMapping{
+		{Claim: "hd", Value: "example.com", Level: "user"},
+		{Claim: "groups", Value: "admins@example.com", Level: "admin"},
+	}
+	user := goth.User{RawData: map[string]interface{}{
+		"hd":     "other.com",
+		"groups": []interface{}{"staff@example.com", "admins@example.com"},
+	}}
+
+	e := mapping.Explain(user)
+	if e.Level != "admin" || e.Index != 1 || e.Rule == nil || e.Rule.Claim != "groups" {
+		t.Errorf("explanation is %+v", e)
+	}
+	if e := mapping.Explain(goth.User{}); e.Level != "" || e.Index != -1 || e.Rule != nil {
+		t.Errorf("explanation of unmatched user is %+v", e)
+	}
+}
+
+func TestClaimMappingString(t *testing.T) {
+	text := "hd = example.com -> user\ngroups = admins@example.com -> admin"
+	mapping, err := ParseClaimMapping(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapping.String() != text {
+		t.Errorf("mapping is formatted as %q", mapping.String())
+	}
+}
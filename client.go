@@ -0,0 +1,100 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+var httpClient *http.Client
+
+// defaultClient is set to providers without SetHTTPClient, so calls left to finish
+// in background after the request is cancelled don't hang forever
+var defaultClient = &http.Client{Timeout: 30 * time.Second}
+
+// SetHTTPClient defines client used by providers for token exchange and fetching of the user,
+// it is set to providers which expose HTTPClient field (all built-in goth providers) and don't
+// have own client. Use NewHTTPClient for a client with timeout and retries, nil restores
+// the default client with 30 seconds timeout
+func SetHTTPClient(client *http.Client) {
+	previous := providerClient()
+	httpClient = client
+	for _, p := range providers {
+		applyHTTPClient(p, previous)
+	}
+}
+
+// providerClient returns the client of SetHTTPClient, or the default one
+func providerClient() *http.Client {
+	if httpClient != nil {
+		return httpClient
+	}
+	return defaultClient
+}
+
+// applyHTTPClient sets the client to the provider, unless the provider has a client of its own
+func applyHTTPClient(p goth.Provider, previous *http.Client) {
+	v := reflect.ValueOf(p)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	field := v.Elem().FieldByName("HTTPClient")
+	if !field.IsValid() || !field.CanSet() || field.Type() != reflect.TypeOf(httpClient) {
+		return
+	}
+
+	current := field.Interface().(*http.Client)
+	if current == nil || current == previous {
+		field.Set(reflect.ValueOf(providerClient()))
+	}
+}
+
+// NewHTTPClient returns client with the timeout of each request,
+// GET requests (e.g. fetching the user) are repeated on network errors and 5xx responses
+// with exponential backoff, up to the count of retries
+func NewHTTPClient(timeout time.Duration, retries int) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &retryTransport{base: http.DefaultTransport, retries: retries, delay: 100 * time.Millisecond},
+	}
+}
+
+type retryTransport struct {
+	base    http.RoundTripper
+	retries int
+	delay   time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		// token exchange uses single-use code, it can't be repeated safely
+		return t.base.RoundTrip(req)
+	}
+
+	delay := t.delay
+	for attempt := 0; ; attempt++ {
+		res, err := t.base.RoundTrip(req)
+		if err == nil && res.StatusCode < 500 {
+			return res, nil
+		}
+		if attempt >= t.retries {
+			return res, err
+		}
+
+		if err == nil {
+			res.Body.Close()
+			err = fmt.Errorf("unexpected status %d", res.StatusCode)
+		}
+		logger.Debugf("Request to %s failed (attempt %d), %s", req.URL.Host, attempt+1, err.Error())
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
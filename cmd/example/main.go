@@ -0,0 +1,39 @@
+// Command example serves the example application, credentials are read from
+// GOOGLE_KEY, GOOGLE_SECRET, GITHUB_KEY and GITHUB_SECRET environment variables
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/mkozhukh/login/example"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen")
+	base := flag.String("url", "http://localhost:8080", "public url of the application")
+	admins := flag.String("admins", "", "comma separated emails of admins")
+	flag.Parse()
+
+	cfg := example.Config{
+		BaseURL:      *base,
+		GoogleKey:    os.Getenv("GOOGLE_KEY"),
+		GoogleSecret: os.Getenv("GOOGLE_SECRET"),
+		GitHubKey:    os.Getenv("GITHUB_KEY"),
+		GitHubSecret: os.Getenv("GITHUB_SECRET"),
+	}
+	if *admins != "" {
+		cfg.Admins = strings.Split(*admins, ",")
+	}
+
+	handler, err := example.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, handler))
+}
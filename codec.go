@@ -0,0 +1,101 @@
+package login
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// maxSessionValue limits size of provider session data, before and after decompression
+const maxSessionValue = 64 << 10
+
+// envelope bytes of stored session values, values written before the envelope
+// was introduced start with the gzip header
+const (
+	plainValue = 0x00
+	gzipValue  = 0x01
+)
+
+var compressThreshold = 512
+
+// SetCompressThreshold defines size of provider session data from which it is gzipped,
+// smaller values are stored as is. Zero compresses all values
+func SetCompressThreshold(size int) {
+	compressThreshold = size
+}
+
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+var gzipReaders sync.Pool
+
+// encodeValue returns the value in the envelope, the result is not shared with pools
+func encodeValue(value string) ([]byte, error) {
+	if len(value) < compressThreshold {
+		b := make([]byte, 1+len(value))
+		b[0] = plainValue
+		copy(b[1:], value)
+		return b, nil
+	}
+
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buf)
+	buf.Reset()
+	buf.WriteByte(gzipValue)
+
+	gz := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(gz)
+	gz.Reset(buf)
+	if _, err := io.WriteString(gz, value); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// decodeValue unpacks the envelope, corrupted or oversized data is reported as ErrSessionMissing
+func decodeValue(value []byte) (string, error) {
+	if len(value) == 0 {
+		return "", ErrSessionMissing
+	}
+	if len(value) > maxSessionValue {
+		return "", fmt.Errorf("%w: session data is too large, %d bytes", ErrSessionMissing, len(value))
+	}
+
+	switch {
+	case value[0] == plainValue:
+		return string(value[1:]), nil
+	case value[0] == gzipValue:
+		value = value[1:]
+	case len(value) < 2 || value[0] != 0x1f || value[1] != 0x8b:
+		return "", fmt.Errorf("%w: corrupt session data: unknown format", ErrSessionMissing)
+	}
+
+	var gz *gzip.Reader
+	var err error
+	if r, ok := gzipReaders.Get().(*gzip.Reader); ok {
+		gz, err = r, r.Reset(bytes.NewReader(value))
+	} else {
+		gz, err = gzip.NewReader(bytes.NewReader(value))
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: corrupt session data: %w", ErrSessionMissing, err)
+	}
+	defer gzipReaders.Put(gz)
+
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buf)
+	buf.Reset()
+	if _, err := buf.ReadFrom(io.LimitReader(gz, maxSessionValue+1)); err != nil {
+		return "", fmt.Errorf("%w: corrupt session data: %w", ErrSessionMissing, err)
+	}
+	if buf.Len() > maxSessionValue {
+		return "", fmt.Errorf("%w: session data is too large", ErrSessionMissing)
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,130 @@
+package login
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// gzipped returns the value as it was stored before the envelope, or with the given prefix
+func gzipped(prefix []byte, value string) []byte {
+	var b bytes.Buffer
+	b.Write(prefix)
+	gz := gzip.NewWriter(&b)
+	gz.Write([]byte(value))
+	gz.Close()
+	return b.Bytes()
+}
+
+func FuzzDecode(f *testing.F) {
+	small, _ := encodeValue("provider session")
+	large, _ := encodeValue(strings.Repeat(`{"AuthURL":"https://accounts.google.com/o/oauth2/auth"}`, 50))
+	f.Add(small)
+	f.Add(large)
+	f.Add(large[:len(large)/2])
+	f.Add(gzipped(nil, "legacy session"))
+	f.Add(gzipped([]byte{gzipValue}, strings.Repeat("a", 2*maxSessionValue)))
+	f.Add([]byte{})
+	f.Add([]byte{plainValue})
+	f.Add([]byte{gzipValue})
+	f.Add([]byte{0x1f})
+	f.Add([]byte{0x1f, 0x8b, 0x08})
+	f.Add([]byte("not a session"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		value, err := decodeValue(data)
+		if err != nil {
+			if !errors.Is(err, ErrSessionMissing) {
+				t.Fatalf("error %v is not ErrSessionMissing", err)
+			}
+			return
+		}
+		if len(value) > maxSessionValue {
+			t.Fatalf("decoded %d bytes, more than the limit", len(value))
+		}
+
+		encoded, err := encodeValue(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := decodeValue(encoded)
+		if err != nil || again != value {
+			t.Fatalf("value doesn't survive the round trip, %v", err)
+		}
+	})
+}
+
+// unpooledEncode and unpooledDecode are the codec before pooled buffers, kept to measure the reduction
+func unpooledEncode(value string) []byte {
+	var b bytes.Buffer
+	b.WriteByte(gzipValue)
+	gz := gzip.NewWriter(&b)
+	gz.Write([]byte(value))
+	gz.Flush()
+	gz.Close()
+	return b.Bytes()
+}
+
+func unpooledDecode(value []byte) (string, error) {
+	r, err := gzip.NewReader(bytes.NewReader(value[1:]))
+	if err != nil {
+		return "", err
+	}
+	s, err := io.ReadAll(io.LimitReader(r, maxSessionValue+1))
+	return string(s), err
+}
+
+// benchValue is a provider session above the compress threshold
+var benchValue = strings.Repeat(`{"AuthURL":"https://accounts.google.com/o/oauth2/auth","AccessToken":"ya29.a0AfH6SM"}`, 20)
+
+func BenchmarkEncodeValue(b *testing.B) {
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := encodeValue(benchValue); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			unpooledEncode(benchValue)
+		}
+	})
+}
+
+func BenchmarkDecodeValue(b *testing.B) {
+	data, err := encodeValue(benchValue)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := decodeValue(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := unpooledDecode(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("plain", func(b *testing.B) {
+		small, _ := encodeValue("provider session")
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := decodeValue(small); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
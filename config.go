@@ -0,0 +1,302 @@
+package login
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/github"
+	"github.com/markbates/goth/providers/google"
+	"github.com/markbates/goth/providers/gplus"
+)
+
+// Config describes auth provider and routes, see Setup
+type Config struct {
+	// Provider is name of the built-in provider: "google" (default), "gplus", "github" or "oidc"
+	Provider string `json:"provider"`
+	// Issuer is the url of OpenID Connect issuer for "oidc" provider, e.g. "https://keycloak.example.com/realms/main"
+	Issuer string `json:"issuer"`
+	Key    string `json:"key"`
+	Secret string `json:"secret"`
+	// Callback is the absolute url registered at the provider, its path is used for the callback route
+	Callback string `json:"callback"`
+
+	// LoginURL and LogoutURL are paths of the routes, "/login" and "/logout" by default
+	LoginURL  string `json:"login_url"`
+	LogoutURL string `json:"logout_url"`
+
+	// Scopes are requested in addition to the email scope of the provider,
+	// e.g. "https://www.googleapis.com/auth/drive.readonly" or "read:org" for GitHub
+	Scopes []string `json:"scopes"`
+	// AuthParams are added to the auth url of the provider: "prompt", "access_type",
+	// "login_hint", "include_granted_scopes" or "hd"
+	AuthParams map[string]string `json:"auth_params"`
+
+	// Providers are offered side by side instead of the single Provider, they share the routes,
+	// provider of the callback is passed in "provider" parameter of the Callback
+	Providers []ProviderConfig `json:"providers"`
+}
+
+// ProviderConfig describes one of several providers of Config
+type ProviderConfig struct {
+	// Provider is name of the built-in provider, e.g. "google"
+	Provider string   `json:"provider"`
+	Issuer   string   `json:"issuer"`
+	Key      string   `json:"key"`
+	Secret   string   `json:"secret"`
+	Scopes   []string `json:"scopes"`
+	// AuthParams are added to the auth url of the provider, see Config.AuthParams
+	AuthParams map[string]string `json:"auth_params"`
+}
+
+// ConfigError lists all problems found in the configuration
+type ConfigError []string
+
+func (e ConfigError) Error() string {
+	return "invalid auth config: " + strings.Join(e, "; ")
+}
+
+var providerFactories = map[string]func(cfg Config) goth.Provider{
+	"google": func(cfg Config) goth.Provider {
+		return google.New(cfg.Key, cfg.Secret, cfg.Callback, cfg.scopes()...)
+	},
+	"gplus": func(cfg Config) goth.Provider {
+		return gplus.New(cfg.Key, cfg.Secret, cfg.Callback, cfg.scopes()...)
+	},
+	"github": func(cfg Config) goth.Provider {
+		return github.New(cfg.Key, cfg.Secret, cfg.Callback, cfg.scopes()...)
+	},
+	"oidc": func(cfg Config) goth.Provider {
+		return NewOIDCProvider(cfg.Issuer, cfg.Key, cfg.Secret, cfg.Callback, cfg.scopes()...)
+	},
+}
+
+// emailScopes give access to the email of the user at each built-in provider
+var emailScopes = map[string]string{
+	"google": "email",
+	"gplus":  "email",
+	// private addresses of GitHub users are read with this scope too
+	"github": "user:email",
+	"oidc":   "email",
+}
+
+func (c Config) provider() string {
+	if c.Provider == "" {
+		return "google"
+	}
+	return c.Provider
+}
+
+func (c Config) scopes() []string {
+	email := emailScopes[c.provider()]
+	scopes := []string{email}
+	for _, s := range c.Scopes {
+		if s != email {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// providerConfigs returns configuration of each provider, with the callback marked by the provider name
+func (c Config) providerConfigs() []Config {
+	if len(c.Providers) == 0 {
+		return []Config{c}
+	}
+
+	sep := "?"
+	if strings.Contains(c.Callback, "?") {
+		sep = "&"
+	}
+	list := make([]Config, 0, len(c.Providers))
+	for _, p := range c.Providers {
+		one := c
+		one.Providers = nil
+		one.Provider, one.Issuer, one.Key, one.Secret = p.Provider, p.Issuer, p.Key, p.Secret
+		one.Scopes, one.AuthParams = p.Scopes, p.AuthParams
+		one.Callback = c.Callback + sep + "provider=" + url.QueryEscape(one.provider())
+		list = append(list, one)
+	}
+	return list
+}
+
+func (c Config) loginURL() string {
+	if c.LoginURL == "" {
+		return "/login"
+	}
+	return c.LoginURL
+}
+
+func (c Config) logoutURL() string {
+	if c.LogoutURL == "" {
+		return "/logout"
+	}
+	return c.LogoutURL
+}
+
+// Validate checks the configuration, all found problems are returned as ConfigError
+func (c Config) Validate() error {
+	return c.validate(store != nil)
+}
+
+// validate checks the configuration, sessionSet tells that the session store will be available
+func (c Config) validate(sessionSet bool) error {
+	var problems ConfigError
+
+	if len(c.Providers) == 0 {
+		problems = append(problems, c.validateProvider("")...)
+	} else {
+		if c.Provider != "" || c.Issuer != "" || c.Key != "" || c.Secret != "" || len(c.Scopes) > 0 || len(c.AuthParams) > 0 {
+			problems = append(problems, "provider is defined both at top level and in providers")
+		}
+		names := map[string]bool{}
+		for _, one := range c.providerConfigs() {
+			if names[one.provider()] {
+				problems = append(problems, fmt.Sprintf("provider %q is defined twice", one.provider()))
+			}
+			names[one.provider()] = true
+			problems = append(problems, one.validateProvider(one.provider()+": ")...)
+		}
+	}
+
+	if c.Callback == "" {
+		problems = append(problems, "callback url is empty")
+	} else if u, err := url.Parse(c.Callback); err != nil {
+		problems = append(problems, fmt.Sprintf("callback url %q is malformed, %s", c.Callback, err.Error()))
+	} else {
+		if u.Scheme != "http" && u.Scheme != "https" {
+			problems = append(problems, fmt.Sprintf("callback url %q must start with http:// or https://", c.Callback))
+		}
+		if u.Host == "" {
+			problems = append(problems, fmt.Sprintf("callback url %q has no host", c.Callback))
+		}
+		if u.Path == c.loginURL() || u.Path == c.logoutURL() {
+			problems = append(problems, fmt.Sprintf("callback url %q uses the path of login or logout route", c.Callback))
+		}
+	}
+
+	for _, route := range []string{c.loginURL(), c.logoutURL()} {
+		if !strings.HasPrefix(route, "/") {
+			problems = append(problems, fmt.Sprintf("route %q must start with /", route))
+		}
+	}
+	if c.loginURL() == c.logoutURL() {
+		problems = append(problems, "login and logout routes are the same")
+	}
+	if !sessionSet {
+		problems = append(problems, "session store is not set, call SetSession first")
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// validateProvider checks settings of one provider, problems are reported with the prefix
+func (c Config) validateProvider(prefix string) []string {
+	var problems []string
+	if _, ok := providerFactories[c.provider()]; !ok {
+		problems = append(problems, fmt.Sprintf("%sunknown provider %q", prefix, c.provider()))
+	}
+	if c.provider() == "oidc" {
+		if u, err := url.Parse(c.Issuer); c.Issuer == "" || err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("%sissuer url %q must be an absolute http(s) url", prefix, c.Issuer))
+		}
+	} else if c.Issuer != "" {
+		problems = append(problems, fmt.Sprintf("%sissuer is set for provider %q, it is used only by oidc", prefix, c.provider()))
+	}
+	if strings.TrimSpace(c.Key) == "" {
+		problems = append(problems, prefix+"client key is empty")
+	}
+	if strings.TrimSpace(c.Secret) == "" {
+		problems = append(problems, prefix+"client secret is empty")
+	}
+	for _, s := range c.Scopes {
+		if strings.TrimSpace(s) == "" || strings.ContainsAny(s, " ,") {
+			problems = append(problems, fmt.Sprintf("%sscope %q is malformed", prefix, s))
+		}
+	}
+	keys := make([]string, 0, len(c.AuthParams))
+	for key := range c.AuthParams {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := c.AuthParams[key]
+		check, ok := authParamChecks[key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%sunknown auth parameter %q", prefix, key))
+		} else if !check(value) {
+			problems = append(problems, fmt.Sprintf("%sauth parameter %s has invalid value %q", prefix, key, value))
+		}
+	}
+	return problems
+}
+
+// authParamChecks validate values of auth url parameters supported by Config
+var authParamChecks = map[string]func(value string) bool{
+	"prompt": func(value string) bool {
+		for _, p := range strings.Fields(value) {
+			if p != "none" && p != "consent" && p != "select_account" {
+				return false
+			}
+		}
+		return value != ""
+	},
+	"access_type": func(value string) bool {
+		return value == "online" || value == "offline"
+	},
+	"include_granted_scopes": func(value string) bool {
+		return value == "true" || value == "false"
+	},
+	"login_hint": func(value string) bool {
+		return strings.TrimSpace(value) != ""
+	},
+	"hd": func(value string) bool {
+		return strings.TrimSpace(value) != ""
+	},
+}
+
+// authParams converts AuthParams for SetAuthParams
+func (c Config) authParams() url.Values {
+	params := url.Values{}
+	for key, value := range c.AuthParams {
+		params.Set(key, value)
+	}
+	return params
+}
+
+// Setup validates the configuration, creates providers and adds their routes
+func Setup(cfg Config, r Router, handler Handler) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	// validated already
+	callback, _ := url.Parse(cfg.Callback)
+	callbackURL := callback.Path
+	if callbackURL == "" {
+		callbackURL = "/"
+	}
+
+	if len(cfg.Providers) == 0 {
+		provider := providerFactories[cfg.provider()](cfg)
+		providerScopes[provider.Name()] = cfg.scopes()
+		SetAuthParams(provider.Name(), cfg.authParams())
+		SetProvider(provider, r, cfg.loginURL(), cfg.logoutURL(), callbackURL, handler)
+		return nil
+	}
+
+	for _, one := range cfg.providerConfigs() {
+		provider := providerFactories[one.provider()](one)
+		providerScopes[provider.Name()] = one.scopes()
+		SetAuthParams(provider.Name(), one.authParams())
+		AddProvider(provider)
+	}
+	// login without the parameter shows buttons of all providers
+	SetRoutes(r, cfg.loginURL(), cfg.logoutURL(), callbackURL, handler, ProviderFromQuery("provider"))
+	return nil
+}
@@ -0,0 +1,24 @@
+package login
+
+import "context"
+
+// withContext runs the provider call and returns early when the context is cancelled.
+// goth providers don't accept a context, so the call itself is left to finish in background,
+// within the timeout of the provider's client
+func withContext(ctx context.Context, call func() error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- call()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,25 @@
+package login
+
+import "net/http"
+
+var debugMode = false
+
+// SetDebug enables logging of each step of the OAuth flow,
+// states and tokens are redacted in the output
+func SetDebug(debug bool) {
+	debugMode = debug
+}
+
+func debugf(req *http.Request, format string, args ...interface{}) {
+	if debugMode {
+		logger.Infof(logPrefix(req)+"auth debug: "+format, args...)
+	}
+}
+
+// redact hides all but a short prefix of a secret value
+func redact(secret string) string {
+	if len(secret) <= 4 {
+		return "***"
+	}
+	return secret[:4] + "***"
+}
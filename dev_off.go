@@ -0,0 +1,6 @@
+//go:build !dev
+// +build !dev
+
+package login
+
+const devBuild = false
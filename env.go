@@ -0,0 +1,45 @@
+package login
+
+import (
+	"os"
+	"strings"
+)
+
+// ConfigFromEnv reads configuration from environment variables with the prefix,
+// e.g. for prefix "AUTH": AUTH_PROVIDER, AUTH_ISSUER, AUTH_KEY, AUTH_SECRET, AUTH_CALLBACK,
+// AUTH_LOGIN_URL, AUTH_LOGOUT_URL, comma separated AUTH_SCOPES, and auth url parameters AUTH_PROMPT,
+// AUTH_ACCESS_TYPE, AUTH_LOGIN_HINT, AUTH_INCLUDE_GRANTED_SCOPES, AUTH_HD. Use Config.Validate or Setup to check the result
+func ConfigFromEnv(prefix string) Config {
+	if prefix != "" && !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+
+	var scopes []string
+	for _, s := range strings.Split(os.Getenv(prefix+"SCOPES"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			scopes = append(scopes, s)
+		}
+	}
+
+	var params map[string]string
+	for key := range authParamChecks {
+		if value := os.Getenv(prefix + strings.ToUpper(key)); value != "" {
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[key] = value
+		}
+	}
+
+	return Config{
+		Provider:   os.Getenv(prefix + "PROVIDER"),
+		Issuer:     os.Getenv(prefix + "ISSUER"),
+		Key:        os.Getenv(prefix + "KEY"),
+		Secret:     os.Getenv(prefix + "SECRET"),
+		Callback:   os.Getenv(prefix + "CALLBACK"),
+		LoginURL:   os.Getenv(prefix + "LOGIN_URL"),
+		LogoutURL:  os.Getenv(prefix + "LOGOUT_URL"),
+		Scopes:     scopes,
+		AuthParams: params,
+	}
+}
@@ -0,0 +1,129 @@
+package login
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/markbates/goth"
+)
+
+// Errors returned by the authentication flow, use errors.Is to check them
+var (
+	ErrNoProvider     = errors.New("no provider for this request")
+	ErrSessionMissing = errors.New("could not find a matching session for this request")
+	ErrStateMismatch  = errors.New("state token mismatch")
+	ErrStateExpired   = errors.New("state token expired")
+	ErrCallbackReused = errors.New("callback was already used")
+	ErrAccessDenied   = errors.New("access denied by provider")
+	ErrUserDenied     = errors.New("access denied for user")
+	ErrBadCredentials = errors.New("invalid email or password")
+	ErrInvalidToken   = errors.New("invalid id token")
+	ErrBadRedirect    = errors.New("redirect target is not allowed")
+	ErrMaintenance    = errors.New("login is restricted during maintenance")
+)
+
+var errorHandler func(res http.ResponseWriter, req *http.Request, err error)
+
+// SetErrorHandler defines a function which renders authentication failures,
+// by default the error page is shown
+func SetErrorHandler(handler func(res http.ResponseWriter, req *http.Request, err error)) {
+	errorHandler = handler
+}
+
+// handleError passes error to the custom error handler or renders the error page with a safe message
+func handleError(res http.ResponseWriter, req *http.Request, key string, err error) {
+	if ErrorStatus(err) == http.StatusInternalServerError {
+		reportError(req, err)
+	}
+
+	if errorHandler != nil {
+		logger.Errorf("%s%s, %s", logPrefix(req), catalog["en"][key], err.Error())
+		errorHandler(res, req, err)
+		return
+	}
+
+	renderError(res, req, ErrorStatus(err), key, err)
+}
+
+// FailureHandler can be implemented by Handler to respond to failed authentications,
+// it takes precedence over SetErrorHandler for the routes of the handler
+type FailureHandler interface {
+	OnError(req *http.Request, res http.ResponseWriter, err error)
+}
+
+// handleFailure passes error of the callback to Handler.OnError when it is implemented,
+// other handlers get the default processing
+func handleFailure(res http.ResponseWriter, req *http.Request, handler Handler, provider string, err error) {
+	fh, ok := handler.(FailureHandler)
+	if !ok {
+		handleProviderError(res, req, provider, err)
+		return
+	}
+
+	if ErrorStatus(err) == http.StatusInternalServerError {
+		reportError(req, err)
+	}
+	logger.Errorf("%s%s, %s", logPrefix(req), catalog["en"][msgCompleteFailed], err.Error())
+	fh.OnError(req, res, err)
+}
+
+// retryPages replace the "try again" link for providers which don't use the login route
+var retryPages = map[string]string{}
+
+// handleProviderError shows the error page with "try again" link when the user declined
+// access at the provider or the login session is lost, other errors are passed to handleError
+func handleProviderError(res http.ResponseWriter, req *http.Request, provider string, err error) {
+	key := ""
+	switch {
+	case errors.Is(err, ErrAccessDenied):
+		key = msgDeclined
+	case errors.Is(err, ErrSessionMissing):
+		key = msgSessionExpired
+	case errors.Is(err, ErrBadCredentials):
+		key = msgBadCredentials
+	}
+	if errorHandler != nil || key == "" {
+		handleError(res, req, msgCompleteFailed, err)
+		return
+	}
+
+	retry, ok := retryPages[provider]
+	if !ok {
+		retry = loginRoute + "?provider=" + url.QueryEscape(provider)
+	}
+	renderRetry(res, req, ErrorStatus(err), key, retry, err)
+}
+
+// ErrorStatus returns http status matching the authentication error
+func ErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNoProvider):
+		return http.StatusNotFound
+	case errors.Is(err, ErrAccessDenied), errors.Is(err, ErrUserDenied):
+		return http.StatusForbidden
+	case errors.Is(err, ErrStateMismatch), errors.Is(err, ErrStateExpired), errors.Is(err, ErrCallbackReused),
+		errors.Is(err, ErrBadRedirect):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrSessionMissing), errors.Is(err, ErrBadCredentials), errors.Is(err, ErrInvalidToken):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrMaintenance):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// getProvider returns provider added by AddProvider, or registered by goth.UseProviders
+func getProvider(name string) (goth.Provider, error) {
+	if provider, ok := providers[name]; ok {
+		return provider, nil
+	}
+
+	provider, err := goth.GetProvider(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoProvider, name)
+	}
+	return provider, nil
+}
@@ -0,0 +1,196 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+// EventType describes the kind of authentication event
+type EventType string
+
+// Known event types
+const (
+	LoginSuccess  EventType = "login"
+	LoginFailure  EventType = "login_failed"
+	LoginDenied   EventType = "login_denied"
+	LogoutSuccess EventType = "logout"
+	Elevation     EventType = "elevation"
+)
+
+// Event contains details of an authentication attempt
+type Event struct {
+	Type      EventType
+	Provider  string
+	Email     string
+	User      goth.User
+	RequestID string
+	IP        string
+	UserAgent string
+	Error     error
+	// Scopes are requested from the provider by Setup, tokens are available in User
+	Scopes []string
+	// Tenant is the name of the tenant of the request, see SetTenants
+	Tenant string
+	// Session is set for logout events only
+	Session *SessionSnapshot
+}
+
+// SessionSnapshot describes the session at the moment of logout
+type SessionSnapshot struct {
+	Keys      []string
+	LoginTime time.Time
+}
+
+var eventHandler = logEvent
+var trustProxy = false
+
+var hooks = map[EventType][]func(Event){}
+
+// providerScopes contains scopes of providers created by Setup
+var providerScopes = map[string][]string{}
+var vetoHooks []func(Event) error
+var vetoPage = ""
+
+// SetEventHandler defines a function which receives all authentication events
+func SetEventHandler(handler func(Event)) {
+	eventHandler = handler
+}
+
+// OnLogin registers a function called after each successful login, once the session is saved
+func OnLogin(hook func(Event)) {
+	hooks[LoginSuccess] = append(hooks[LoginSuccess], hook)
+}
+
+// BeforeLogin registers a function called after authentication and before the gateway,
+// returned error aborts the login without writing any session data
+func BeforeLogin(hook func(Event) error) {
+	vetoHooks = append(vetoHooks, hook)
+}
+
+// SetVetoPage defines url where the user is redirected when login is aborted by BeforeLogin hook,
+// by default the denied page is shown
+func SetVetoPage(url string) {
+	vetoPage = url
+}
+
+// approveLogin runs BeforeLogin hooks, it responds to the request and returns false when login is aborted
+func approveLogin(res http.ResponseWriter, req *http.Request, provider string, user goth.User) bool {
+	for _, hook := range vetoHooks {
+		err := hook(newEvent(req, LoginSuccess, provider, user, nil))
+		if err == nil {
+			continue
+		}
+
+		emitEvent(req, LoginDenied, provider, user, fmt.Errorf("%w: %s", ErrUserDenied, err.Error()))
+		if vetoPage != "" {
+			respond(res, req, vetoPage, nil)
+		} else {
+			renderDenied(res, req, user.Email)
+		}
+		return false
+	}
+	return true
+}
+
+// OnLogout registers a function called on each logout, before the session is destroyed
+func OnLogout(hook func(Event)) {
+	hooks[LogoutSuccess] = append(hooks[LogoutSuccess], hook)
+}
+
+// OnElevation registers a function called when the user is elevated by SetSudo
+func OnElevation(hook func(Event)) {
+	hooks[Elevation] = append(hooks[Elevation], hook)
+}
+
+// OnDenied registers a function called when authentication fails or the user is denied
+func OnDenied(hook func(Event)) {
+	hooks[LoginFailure] = append(hooks[LoginFailure], hook)
+	hooks[LoginDenied] = append(hooks[LoginDenied], hook)
+}
+
+// SetTrustProxy enables reading of client IP from X-Forwarded-For and X-Real-IP headers
+func SetTrustProxy(trust bool) {
+	trustProxy = trust
+}
+
+func logEvent(e Event) {
+	prefix := ""
+	if e.RequestID != "" {
+		prefix = "[" + e.RequestID + "] "
+	}
+
+	if e.Error != nil {
+		logger.Errorf(prefix+"auth: %s provider=%s email=%s ip=%s ua=%q error=%s", e.Type, e.Provider, e.Email, e.IP, e.UserAgent, e.Error.Error())
+	} else {
+		logger.Infof(prefix+"auth: %s provider=%s email=%s ip=%s ua=%q", e.Type, e.Provider, e.Email, e.IP, e.UserAgent)
+	}
+}
+
+const loginAcceptedContextKey contextKey = "login-accepted"
+
+// trackLogin returns the request which records whether the login established the session
+func trackLogin(req *http.Request) (*http.Request, *bool) {
+	accepted := new(bool)
+	return req.WithContext(context.WithValue(req.Context(), loginAcceptedContextKey, accepted)), accepted
+}
+
+// emitLogin reports the login after the session of the user is saved
+func emitLogin(req *http.Request, user goth.User) {
+	if accepted, ok := req.Context().Value(loginAcceptedContextKey).(*bool); ok {
+		*accepted = true
+	}
+	emitEvent(req, LoginSuccess, user.Provider, user, nil)
+}
+
+func emitEvent(req *http.Request, t EventType, provider string, user goth.User, err error) {
+	dispatchEvent(newEvent(req, t, provider, user, err))
+}
+
+func dispatchEvent(e Event) {
+	t := e.Type
+	if eventHandler != nil {
+		eventHandler(e)
+	}
+	for _, hook := range hooks[t] {
+		hook(e)
+	}
+}
+
+func newEvent(req *http.Request, t EventType, provider string, user goth.User, err error) Event {
+	return Event{
+		Type:      t,
+		Provider:  provider,
+		Email:     user.Email,
+		User:      user,
+		RequestID: getRequestID(req),
+		IP:        clientIP(req),
+		UserAgent: req.UserAgent(),
+		Error:     err,
+		Scopes:    providerScopes[provider],
+		Tenant:    CurrentTenant(req),
+	}
+}
+
+// clientIP returns address of the client, respecting proxy headers when they are trusted
+func clientIP(req *http.Request) string {
+	if trustProxy {
+		if fwd := req.Header.Get("X-Forwarded-For"); fwd != "" {
+			return strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+		if real := req.Header.Get("X-Real-IP"); real != "" {
+			return strings.TrimSpace(real)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
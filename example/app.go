@@ -0,0 +1,129 @@
+// Package example is a small application which uses the login package,
+// its handlers can be served by cmd/example or used in tests
+package example
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/alexedwards/scs"
+	"github.com/alexedwards/scs/stores/memstore"
+	"github.com/markbates/goth/providers/github"
+	"github.com/markbates/goth/providers/google"
+	"github.com/mkozhukh/login"
+)
+
+// Config contains credentials of the providers, a provider without key is not enabled
+type Config struct {
+	// BaseURL is the public url of the application, e.g. "http://localhost:8080"
+	BaseURL string
+
+	GoogleKey    string
+	GoogleSecret string
+	GitHubKey    string
+	GitHubSecret string
+
+	// Admins can open the admin api
+	Admins []string
+}
+
+// App contains handlers of the application
+type App struct {
+	admins map[string]bool
+}
+
+// Router adapts http.ServeMux to login.Router
+type Router struct {
+	*http.ServeMux
+}
+
+// Get registers handler of GET requests
+func (r Router) Get(pattern string, handler http.HandlerFunc) {
+	r.HandleFunc("GET "+pattern, handler)
+}
+
+// New configures the login package and returns handler of the whole application
+func New(cfg Config) (http.Handler, error) {
+	session := scs.NewManager(memstore.New(time.Minute))
+	login.SetSession(session)
+
+	if cfg.GoogleKey != "" {
+		login.AddProvider(google.New(cfg.GoogleKey, cfg.GoogleSecret, cfg.BaseURL+"/callback?provider=google", "email"))
+	}
+	if cfg.GitHubKey != "" {
+		login.AddProvider(github.New(cfg.GitHubKey, cfg.GitHubSecret, cfg.BaseURL+"/callback?provider=github", "user:email"))
+	}
+
+	app := &App{admins: make(map[string]bool, len(cfg.Admins))}
+	for _, email := range cfg.Admins {
+		app.admins[email] = true
+	}
+
+	r := Router{http.NewServeMux()}
+	// without the provider parameter the login route shows buttons of all providers
+	login.SetRoutes(r, "/login", "/logout", "/callback", app, login.ProviderFromQuery("provider"))
+
+	login.OnLogin(func(e login.Event) { log.Printf("%s signed in with %s", e.Email, e.Provider) })
+	login.OnDenied(func(e login.Event) { log.Printf("login failed for %q from %s", e.Email, e.IP) })
+
+	r.HandleFunc("GET /{$}", app.Home)
+	r.Handle("GET /app", login.RequireAuthenticated(http.HandlerFunc(app.Dashboard)))
+	r.Handle("GET /admin/stats", login.Protect(http.HandlerFunc(app.Stats), app.IsAdmin))
+	r.Handle("GET /admin/metrics", login.Protect(login.MetricsHandler(), app.IsAdmin))
+
+	if err := login.SetUserFields("name", "avatar"); err != nil {
+		return nil, err
+	}
+	return session.Use(r), nil
+}
+
+// Login implements login.Handler, all users with verified email can sign in
+func (a *App) Login(req *http.Request, res http.ResponseWriter, email string) string {
+	if email == "" {
+		return ""
+	}
+	return "/app"
+}
+
+// Logout implements login.Handler
+func (a *App) Logout(req *http.Request, res http.ResponseWriter) string {
+	return "/"
+}
+
+// IsAdmin checks that the user can use the admin api
+func (a *App) IsAdmin(email string) bool {
+	return a.admins[email]
+}
+
+var homePage = template.Must(template.New("home").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Example</title></head>
+<body>
+{{if .Email}}<p>Signed in as {{.Email}}, <a href="/app">open the app</a> or <a href="/logout">log out</a></p>
+{{else}}<p><a href="/login">Sign in</a></p>{{end}}
+</body></html>`))
+
+// Home is the public page
+func (a *App) Home(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := homePage.Execute(res, login.GetUser(req)); err != nil {
+		log.Printf("can't render home page, %s", err)
+	}
+}
+
+// Dashboard is available to authenticated users
+func (a *App) Dashboard(res http.ResponseWriter, req *http.Request) {
+	user := login.GetUser(req)
+	fmt.Fprintf(res, "Hello, %s (%s)\n", user.Name, user.Email)
+}
+
+// Stats returns authentication statistics, it is available to admins
+func (a *App) Stats(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(login.Stats()); err != nil {
+		log.Printf("can't write stats, %s", err)
+	}
+}
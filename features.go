@@ -0,0 +1,86 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/markbates/goth"
+)
+
+const featuresKey = "login:features"
+
+// FeatureSource returns feature flags of the user at login
+type FeatureSource func(req *http.Request, user goth.User) ([]string, error)
+
+var featureSource FeatureSource
+
+// SetFeatures defines source of per-user feature flags, the flags are saved in the session
+// at login and checked by HasFeature, so rollouts don't need a lookup per request.
+// Flags change with the next login
+func SetFeatures(source FeatureSource) {
+	featureSource = source
+}
+
+// FeaturesFromClaim takes feature flags from the claim of the provider, a list
+// or a comma separated string, e.g. custom attribute of the identity provider
+func FeaturesFromClaim(claim string) FeatureSource {
+	return func(req *http.Request, user goth.User) ([]string, error) {
+		var flags []string
+		switch v := claimValue(user.RawData, claim).(type) {
+		case nil:
+		case string:
+			flags = strings.Split(v, ",")
+		case []interface{}:
+			for _, item := range v {
+				flags = append(flags, fmt.Sprint(item))
+			}
+		case []string:
+			flags = v
+		default:
+			return nil, fmt.Errorf("claim %s is not a list of features", claim)
+		}
+		return flags, nil
+	}
+}
+
+// Features returns feature flags of the session user
+func Features(req *http.Request) []string {
+	value, _ := loadSession(req).GetString(featuresKey)
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// HasFeature checks that the flag is enabled for the session user
+func HasFeature(req *http.Request, flag string) bool {
+	for _, f := range Features(req) {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func saveFeatures(res http.ResponseWriter, req *http.Request, user goth.User) error {
+	session := loadSession(req)
+	if featureSource == nil {
+		return session.Remove(res, featuresKey)
+	}
+
+	flags, err := featureSource(req, user)
+	if err != nil {
+		return fmt.Errorf("can't get features: %w", err)
+	}
+	clean := make([]string, 0, len(flags))
+	for _, f := range flags {
+		if f = strings.TrimSpace(f); f != "" && !strings.Contains(f, ",") {
+			clean = append(clean, f)
+		}
+	}
+	if len(clean) == 0 {
+		return session.Remove(res, featuresKey)
+	}
+	return session.PutString(res, featuresKey, strings.Join(clean, ","))
+}
@@ -0,0 +1,97 @@
+package login
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/markbates/goth/providers/google"
+	"github.com/markbates/goth/providers/gplus"
+)
+
+// FederatedLogout defines what happens with the Google session of the user on logout
+type FederatedLogout int
+
+// Modes of federated logout
+const (
+	// KeepGoogleSession logs out of the application only, the next login may
+	// silently pick the same account
+	KeepGoogleSession FederatedLogout = iota
+	// SelectAccount shows the account chooser of Google at the next login from the browser
+	SelectAccount
+	// GoogleLogout signs the user out of Google as well, all Google services of the browser are affected.
+	// Sessions of other providers are logged out of the application only
+	GoogleLogout
+)
+
+const selectAccountKey = "login:select_account"
+
+// googleLogoutURL ends the Google session and continues to appEngineLogoutURL, which returns to
+// its own continue url, so that url is escaped twice
+const (
+	googleLogoutURL    = "https://accounts.google.com/Logout?continue="
+	appEngineLogoutURL = "https://appengine.google.com/_ah/logout?continue="
+)
+
+var federatedLogout = KeepGoogleSession
+
+// SetFederatedLogout defines what happens with the Google session on logout,
+// use it for shared machines where the next user must not get the previous account
+func SetFederatedLogout(mode FederatedLogout) {
+	federatedLogout = mode
+}
+
+// federatedTarget applies federated logout to the page shown after logout,
+// provider is the one of the session which is logged out
+func federatedTarget(res http.ResponseWriter, req *http.Request, provider, target string) string {
+	switch federatedLogout {
+	case SelectAccount:
+		if err := loadSession(req).PutBool(res, selectAccountKey, true); err != nil {
+			reportError(req, err)
+		}
+	case GoogleLogout:
+		if !isGoogle(provider) {
+			return target
+		}
+		back := appEngineLogoutURL + url.QueryEscape(absoluteURL(req, target))
+		return googleLogoutURL + url.QueryEscape(back)
+	}
+	return target
+}
+
+// isGoogle checks kind of the provider, names of tenants' providers are prefixed by the tenant
+func isGoogle(name string) bool {
+	switch providers[name].(type) {
+	case *google.Provider, *gplus.Provider:
+		return true
+	}
+	kind := name[strings.LastIndex(name, ":")+1:]
+	return kind == "google" || kind == "gplus"
+}
+
+// selectAccount returns auth url which shows the account chooser, when it is required by the logout
+func selectAccount(res http.ResponseWriter, req *http.Request, authURL string) (string, error) {
+	session := loadSession(req)
+	if required, _ := session.GetBool(selectAccountKey); !required {
+		return authURL, nil
+	}
+	if err := session.Remove(res, selectAccountKey); err != nil {
+		return "", err
+	}
+
+	return extendAuthURL(authURL, url.Values{"prompt": {"select_account"}})
+}
+
+// absoluteURL resolves path of the application against the url of the request
+func absoluteURL(req *http.Request, path string) string {
+	scheme := "http"
+	if req.TLS != nil || (trustProxy && req.Header.Get("X-Forwarded-Proto") == "https") {
+		scheme = "https"
+	}
+	base := &url.URL{Scheme: scheme, Host: req.Host, Path: "/"}
+	target, err := base.Parse(path)
+	if err != nil {
+		return base.String()
+	}
+	return target.String()
+}
@@ -0,0 +1,55 @@
+package login
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/markbates/goth/providers/google"
+)
+
+func TestGoogleLogout(t *testing.T) {
+	SetFederatedLogout(GoogleLogout)
+	defer SetFederatedLogout(KeepGoogleSession)
+	req := httptest.NewRequest("GET", "http://example.com/logout", nil)
+	target := "/bye?from=logout&lang=de"
+
+	for _, provider := range []string{"gplus", "acme:google", "acme:gplus"} {
+		if got := federatedTarget(httptest.NewRecorder(), req, provider, target); !strings.HasPrefix(got, googleLogoutURL) {
+			t.Errorf("session of %q isn't logged out of google, %s", provider, got)
+		}
+	}
+
+	logout, err := url.Parse(federatedTarget(httptest.NewRecorder(), req, "google", target))
+	if err != nil || logout.Host != "accounts.google.com" {
+		t.Fatalf("google session is logged out by %s, %v", logout, err)
+	}
+	// each service reads its own continue parameter, the target must survive both
+	appEngine, err := url.Parse(logout.Query().Get("continue"))
+	if err != nil || appEngine.Host != "appengine.google.com" || len(logout.Query()) != 1 {
+		t.Fatalf("google logout continues to %s, %v", appEngine, err)
+	}
+	if back := appEngine.Query().Get("continue"); back != "http://example.com"+target || len(appEngine.Query()) != 1 {
+		t.Errorf("app engine logout returns to %s", back)
+	}
+
+	// the kind of registered providers is known by the type, whatever the name is
+	corp := google.New("key", "secret", "https://example.com/callback")
+	corp.SetName("corp")
+	previous := enabledProviders
+	AddProvider(corp)
+	defer func() {
+		enabledProviders = previous
+		delete(providers, "corp")
+	}()
+	if got := federatedTarget(httptest.NewRecorder(), req, "corp", target); !strings.HasPrefix(got, googleLogoutURL) {
+		t.Errorf("session of renamed google provider isn't logged out of google, %s", got)
+	}
+
+	for _, provider := range []string{"github", "acme:github", "googleish", ""} {
+		if got := federatedTarget(httptest.NewRecorder(), req, provider, target); got != target {
+			t.Errorf("session of %q is logged out of google, %s", provider, got)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package login
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/alexedwards/scs"
+	"gopkg.in/yaml.v3"
+)
+
+// FileConfig contains all settings of the package, LoadConfig reads it from JSON, YAML or TOML file.
+// Keys are the same in all formats, settings of Config are at the top level of the file
+type FileConfig struct {
+	Config
+
+	Session struct {
+		// Key of the cookie store, the session store is left unchanged when empty
+		Key      string `json:"key"`
+		Name     string `json:"name"`
+		Lifetime string `json:"lifetime"`
+		Secure   bool   `json:"secure"`
+		SameSite string `json:"same_site"`
+	} `json:"session"`
+
+	Pages struct {
+		Logout         string `json:"logout"`
+		Veto           string `json:"veto"`
+		ErrorTemplate  string `json:"error_template"`
+		DeniedTemplate string `json:"denied_template"`
+		LoginTemplate  string `json:"login_template"`
+	} `json:"pages"`
+
+	StateSecret string `json:"state_secret"`
+}
+
+// LoadConfig reads configuration file and sets up session, pages, provider and routes,
+// the format is chosen by the extension: .json, .yaml, .yml or .toml
+func LoadConfig(path string, r Router, handler Handler) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("can't read auth config: %w", err)
+	}
+
+	cfg, err := parseConfig(path, data)
+	if err != nil {
+		return fmt.Errorf("can't parse auth config %s: %w", path, err)
+	}
+
+	return SetupFile(cfg, r, handler)
+}
+
+func parseConfig(path string, data []byte) (FileConfig, error) {
+	var cfg FileConfig
+	var tree interface{}
+	var err error
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &cfg)
+		return cfg, err
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		var table map[string]interface{}
+		_, err = toml.Decode(string(data), &table)
+		tree = table
+	default:
+		return cfg, fmt.Errorf("unknown format %q, use .json, .yaml or .toml", filepath.Ext(path))
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	// the tree is decoded by json tags of the config, so all formats share the keys
+	data, err = json.Marshal(tree)
+	if err != nil {
+		return cfg, err
+	}
+	err = json.Unmarshal(data, &cfg)
+	return cfg, err
+}
+
+// SetupFile applies all settings of the configuration and adds routes of the provider,
+// nothing is changed when the configuration is invalid
+func SetupFile(cfg FileConfig, r Router, handler Handler) error {
+	if err := cfg.Config.validate(store != nil || cfg.Session.Key != ""); err != nil {
+		return err
+	}
+
+	var session *scs.Manager
+	if cfg.Session.Key != "" {
+		session = scs.NewCookieManager(cfg.Session.Key)
+		if cfg.Session.Name != "" {
+			session.Name(cfg.Session.Name)
+		}
+		if cfg.Session.Lifetime != "" {
+			lifetime, err := time.ParseDuration(cfg.Session.Lifetime)
+			if err != nil {
+				return fmt.Errorf("invalid session lifetime %q: %w", cfg.Session.Lifetime, err)
+			}
+			session.Lifetime(lifetime)
+		}
+		session.Secure(cfg.Session.Secure)
+		if cfg.Session.SameSite != "" {
+			session.SameSite(cfg.Session.SameSite)
+		}
+	}
+
+	templates := []struct {
+		path string
+		set  func(*template.Template)
+		tmpl *template.Template
+	}{
+		{path: cfg.Pages.ErrorTemplate, set: SetErrorPage},
+		{path: cfg.Pages.DeniedTemplate, set: SetDeniedPage},
+		{path: cfg.Pages.LoginTemplate, set: SetLoginPage},
+	}
+	for i := range templates {
+		if templates[i].path == "" {
+			continue
+		}
+		tmpl, err := template.ParseFiles(templates[i].path)
+		if err != nil {
+			return fmt.Errorf("can't load template: %w", err)
+		}
+		templates[i].tmpl = tmpl
+	}
+
+	// everything is checked, settings can be applied
+	if session != nil {
+		SetSession(session)
+	}
+	if cfg.Pages.Logout != "" {
+		SetLogoutPage(cfg.Pages.Logout)
+	}
+	if cfg.Pages.Veto != "" {
+		SetVetoPage(cfg.Pages.Veto)
+	}
+	for _, t := range templates {
+		if t.tmpl != nil {
+			t.set(t.tmpl)
+		}
+	}
+	if cfg.StateSecret != "" {
+		SetStateSecret([]byte(cfg.StateSecret), 0)
+	}
+
+	return Setup(cfg.Config, r, handler)
+}
@@ -0,0 +1,147 @@
+package login
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const jsonConfig = `{
+	"providers": [
+		{ "provider": "google", "key": "google-key", "secret": "google-secret", "scopes": ["email", "profile"] },
+		{ "provider": "openid-connect", "issuer": "https://id.example.com", "key": "oidc-key", "secret": "s#cret",
+		  "auth_params": { "prompt": "select_account" } }
+	],
+	"callback": "https://example.com/callback",
+	"login_url": "/signin",
+	"session": { "key": "32-byte-long-secret-key-12345678", "lifetime": "12h", "secure": true, "same_site": "Lax" },
+	"pages": { "logout": "/goodbye" },
+	"state_secret": "it's \"quoted\""
+}`
+
+const yamlConfig = `# login settings
+providers:
+  - provider: google
+    key: google-key
+    secret: "google-secret"
+    scopes: [email, profile]
+  - provider: openid-connect
+    issuer: https://id.example.com
+    key: oidc-key
+    secret: 's#cret' # the hash is a part of the value
+    auth_params: { prompt: select_account }
+callback: https://example.com/callback
+login_url: /signin
+
+session:
+  key: 32-byte-long-secret-key-12345678
+  lifetime: 12h
+  secure: true
+  same_site: Lax
+pages:
+  logout: /goodbye
+state_secret: 'it''s "quoted"'
+`
+
+const tomlConfig = `# login settings
+callback = "https://example.com/callback"
+login_url = '/signin'
+state_secret = "it's \"quoted\""
+
+[[providers]]
+provider = "google"
+key = "google-key"
+secret = "google-secret"
+scopes = [
+	"email",
+	"profile", # trailing comma is allowed
+]
+
+[[providers]]
+provider = "openid-connect"
+issuer = "https://id.example.com"
+key = "oidc-key"
+secret = "s#cret"
+auth_params = { prompt = "select_account" }
+
+[session]
+key = "32-byte-long-secret-key-12345678"
+lifetime = "12h"
+secure = true
+same_site = "Lax"
+
+[pages]
+logout = "/goodbye"
+`
+
+func TestParseConfig(t *testing.T) {
+	expected, err := parseConfig("auth.json", []byte(jsonConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expected.Providers) != 2 || expected.Session.Key == "" || expected.StateSecret != `it's "quoted"` {
+		t.Fatalf("json config is parsed as %+v", expected)
+	}
+
+	for name, data := range map[string]string{"auth.yaml": yamlConfig, "auth.yml": yamlConfig, "auth.TOML": tomlConfig} {
+		cfg, err := parseConfig(name, []byte(data))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(cfg, expected) {
+			t.Errorf("%s is parsed as\n%+v\nexpected\n%+v", name, cfg, expected)
+		}
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	cases := map[string]string{
+		"auth.ini":  "provider = google",
+		"tab.yaml":  "session:\n\tkey: value",
+		"dup.yaml":  "key: a\nkey: b",
+		"line.yaml": "provider google",
+		"list.yaml": "scopes: [email, [profile]]",
+		"type.yaml": "scopes: email",
+		"dup.toml":  "key = \"a\"\nkey = \"b\"",
+		"bare.toml": "provider = google",
+		"end.toml":  "scopes = [\"email\"",
+		"head.toml": "[session\nkey = \"a\"",
+		"key.toml":  "[providers]\nprovider = \"google\"",
+	}
+	for name, data := range cases {
+		if _, err := parseConfig(name, []byte(data)); err == nil {
+			t.Errorf("%s: %q is accepted", name, data)
+		}
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	previous := store
+	defer SetSession(previous)
+	SetSession(nil)
+
+	// the session of the file is missing, and the provider has no secret
+	path := filepath.Join(t.TempDir(), "auth.yaml")
+	data := "provider: google\nkey: key\ncallback: https://example.com/callback\npages:\n  logout: /bye\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	err := LoadConfig(path, muxRouter{mux}, nil)
+	var problems ConfigError
+	if !errors.As(err, &problems) || len(problems) != 2 {
+		t.Fatalf("expected two problems, got %v", err)
+	}
+	if store != nil || logoutPage != "/" {
+		t.Error("settings of invalid config are applied")
+	}
+}
+
+type muxRouter struct{ *http.ServeMux }
+
+func (r muxRouter) Get(pattern string, fn http.HandlerFunc) { r.HandleFunc(pattern, fn) }
@@ -7,46 +7,45 @@ https://github.com/markbates/goth/tree/master/gothic
 */
 
 import (
-	"bytes"
-	"compress/gzip"
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"math/rand"
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 
 	"github.com/alexedwards/scs"
 	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/google"
+	"github.com/markbates/goth/providers/gplus"
+	"golang.org/x/oauth2"
 )
 
 // Store can/should be set by applications using gothic. The default is a cookie store.
 var store *scs.Manager
-var gothicRand *rand.Rand
-
-func init() {
-	gothicRand = rand.New(rand.NewSource(time.Now().UnixNano()))
-}
 
 /*
-BeginAuthHandler is a convenience handler for starting the authentication process.
-It expects to be able to get the name of the provider from the query parameters
-as either "provider" or ":provider".
+BeginAuthHandler is a convenience handler for starting the authentication process
+with the named provider.
 
 BeginAuthHandler will redirect the user to the appropriate authentication end-point
-for the requested provider.
+for the requested provider. Failures are rendered by the error handler.
 
-See https://github.com/markbates/goth/examples/main.go to see this in action.
+Steps of the flow are traced in the context of the request.
 */
-func beginAuthHandler(res http.ResponseWriter, req *http.Request, name string) {
-	url, err := getAuthURL(res, req, name)
+func BeginAuthHandler(res http.ResponseWriter, req *http.Request, name string) {
+	url, err := GetAuthURL(res, req, name)
 	if err != nil {
-		res.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintln(res, err)
+		handleError(res, req, msgStartFailed, err)
+		return
+	}
+
+	if jsonMode || wantsJSON(req) {
+		writeJSON(res, http.StatusOK, map[string]interface{}{
+			"status":   "redirect",
+			"redirect": url,
+		})
 		return
 	}
 
@@ -57,7 +56,13 @@ func beginAuthHandler(res http.ResponseWriter, req *http.Request, name string) {
 // If no state string is associated with the request, one will be generated.
 // This state is sent to the provider and can be retrieved during the
 // callback.
-var setState = func(req *http.Request) string {
+var setState = defaultState
+
+func defaultState(req *http.Request) string {
+	if stateSecret != nil {
+		return signState(localPath(req.URL.Query().Get("returnTo")))
+	}
+
 	state := req.URL.Query().Get("state")
 	if len(state) > 0 {
 		return state
@@ -69,9 +74,7 @@ var setState = func(req *http.Request) string {
 	//
 	// https://auth0.com/docs/protocols/oauth2/oauth-state#keep-reading
 	nonceBytes := make([]byte, 64)
-	for i := 0; i < 64; i++ {
-		nonceBytes[i] = byte(gothicRand.Int63() % 256)
-	}
+	randomBytes(nonceBytes)
 	return base64.URLEncoding.EncodeToString(nonceBytes)
 }
 
@@ -83,91 +86,289 @@ var getState = func(req *http.Request) string {
 }
 
 /*
-GetAuthURL starts the authentication process with the requested provided.
-It will return a URL that should be used to send users to.
+GetAuthURL starts the authentication process with the named provider.
+It will return a URL that should be used to send users to, the provider
+session is saved in the session store.
 
-It expects to be able to get the name of the provider from the query parameters
-as either "provider" or ":provider".
-
-I would recommend using the BeginAuthHandler instead of doing all of these steps
-yourself, but that's entirely up to you.
+It is useful for flows which don't redirect, e.g. opening the URL in a popup,
+otherwise use BeginAuthHandler.
 */
-func getAuthURL(res http.ResponseWriter, req *http.Request, providerName string) (string, error) {
-	provider, err := goth.GetProvider(providerName)
+func GetAuthURL(res http.ResponseWriter, req *http.Request, providerName string) (string, error) {
+	provider, err := getProvider(providerName)
 	if err != nil {
 		return "", err
 	}
-	sess, err := provider.BeginAuth(setState(req))
+	state := setState(req)
+	debugf(req, "%s: generated state %s", providerName, redact(state))
+	if stateSecret != nil {
+		bindState(res, req, state)
+	}
+	var sess goth.Session
+	err = withContext(req.Context(), func() (err error) {
+		sess, err = provider.BeginAuth(state)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
 
-	url, err := sess.GetAuthURL()
+	authURL, err := sess.GetAuthURL()
 	if err != nil {
 		return "", err
 	}
+	if authURL, err = selectAccount(res, req, authURL); err != nil {
+		return "", err
+	}
+	if params, ok := providerAuthParams[providerName]; ok {
+		if authURL, err = extendAuthURL(authURL, params); err != nil {
+			return "", err
+		}
+	}
+	if params, ok := req.Context().Value(authParamsContextKey).(url.Values); ok {
+		if authURL, err = extendAuthURL(authURL, params); err != nil {
+			return "", err
+		}
+	}
 
+	// scopes of an abandoned incremental authorization must not be recorded
+	if err := loadSession(req).Remove(res, pendingScopesKey); err != nil {
+		return "", err
+	}
 	err = storeInSession(providerName, sess.Marshal(), req, res)
 
 	if err != nil {
 		return "", err
 	}
 
-	return url, err
+	return authURL, err
+}
+
+const authParamsContextKey contextKey = "login-auth-params"
+
+// providerAuthParams are added to each auth url of the provider
+var providerAuthParams = map[string]url.Values{}
+
+// SetAuthParams defines parameters added to each auth url of the provider, e.g. "prompt"
+// or "access_type", parameters of WithAuthParams take precedence
+func SetAuthParams(provider string, params url.Values) {
+	providerAuthParams[provider] = params
+}
+
+// WithAuthParams returns the request which adds parameters to the auth url of the provider,
+// e.g. "login_hint", use it with BeginAuthHandler and GetAuthURL
+func WithAuthParams(req *http.Request, params url.Values) *http.Request {
+	merged := url.Values{}
+	if previous, ok := req.Context().Value(authParamsContextKey).(url.Values); ok {
+		for k, v := range previous {
+			merged[k] = v
+		}
+	}
+	for k, v := range params {
+		merged[k] = v
+	}
+	return req.WithContext(context.WithValue(req.Context(), authParamsContextKey, merged))
+}
+
+// extendAuthURL sets the parameters of the auth url
+func extendAuthURL(authURL string, params url.Values) (string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
 }
 
 /*
 CompleteUserAuth does what it says on the tin. It completes the authentication
 process and fetches all of the basic information about the user from the provider.
 
-It expects to be able to get the name of the provider from the query parameters
-as either "provider" or ":provider".
-
-See https://github.com/markbates/goth/examples/main.go to see this in action.
+It must be called from the callback handler of the named provider, the provider
+session is removed afterwards, so each callback can be completed only once.
+Steps of the flow are traced in the context of the request.
 */
-var completeUserAuth = func(res http.ResponseWriter, req *http.Request, providerName string) (goth.User, error) {
-	defer logout(res, req, providerName)
+var CompleteUserAuth = func(res http.ResponseWriter, req *http.Request, providerName string) (goth.User, error) {
+	defer Logout(res, req, providerName)
+	defer clearStateBinding(res, req)
 
-	provider, err := goth.GetProvider(providerName)
+	provider, err := getProvider(providerName)
 	if err != nil {
 		return goth.User{}, err
 	}
 
-	value, err := getFromSession(providerName, req)
+	// provider redirects back with error when user declines the consent
+	if req.URL.Query().Get("error") != "" {
+		return goth.User{}, fmt.Errorf("%w: %s", ErrAccessDenied, req.URL.Query().Get("error"))
+	}
+
+	if stateValidator != nil {
+		if err := stateValidator(req, getState(req)); err != nil {
+			debugf(req, "%s: state %s rejected by validator, %s", providerName, redact(getState(req)), err.Error())
+			return goth.User{}, fmt.Errorf("%w: %s", ErrStateMismatch, err.Error())
+		}
+	}
+
+	sess, err := loadAuthSession(provider, providerName, req)
 	if err != nil {
 		return goth.User{}, err
 	}
 
-	sess, err := provider.UnmarshalSession(value)
+	// fresh callback carries the code, without it the user can be found only with existing session data
+	if req.URL.Query().Get("code") == "" {
+		user, err := fetchUser(req, provider, sess)
+		if err != nil {
+			debugf(req, "%s: user not available with existing session data, %s", providerName, err.Error())
+			return goth.User{}, fmt.Errorf("%w: callback has no code, %w", ErrSessionMissing, err)
+		}
+		return user, nil
+	}
+
+	// the same callback must not be used to establish a second session
+	err = consumeState(getState(req))
 	if err != nil {
 		return goth.User{}, err
 	}
 
-	err = validateState(req, sess)
+	// get new token and retry fetch
+	_, span := startSpan(req, "login.token_exchange", providerName)
+	params := req.URL.Query()
+	var granted []string
+	err = withContext(req.Context(), func() (err error) {
+		granted, err = authorize(req.Context(), provider, sess, params)
+		return err
+	})
+	endSpan(span, "success", err)
 	if err != nil {
-		return goth.User{}, err
+		debugf(req, "%s: token exchange failed, %s", providerName, err.Error())
+		return goth.User{}, fmt.Errorf("token exchange failed: %w", err)
+	}
+	debugf(req, "%s: token exchange completed", providerName)
+
+	// the authorized session is not stored, it would be removed by the deferred Logout
+	gu, err := fetchUser(req, provider, sess)
+	if err == nil && granted != nil {
+		if gu.RawData == nil {
+			gu.RawData = make(map[string]interface{})
+		}
+		gu.RawData["scope"] = strings.Join(granted, " ")
 	}
+	return gu, err
+}
 
-	user, err := provider.FetchUser(sess)
-	if err == nil {
-		// user can be found with existing session data
-		return user, err
+// authorize exchanges the code for tokens and returns scopes granted by the token response,
+// or nil when the provider doesn't report them. Sessions of goth drop the scope of the token,
+// so the code of Google providers is exchanged here
+func authorize(ctx context.Context, provider goth.Provider, sess goth.Session, params goth.Params) ([]string, error) {
+	var cfg *oauth2.Config
+	var client *http.Client
+	switch p := provider.(type) {
+	case *google.Provider:
+		cfg, client = googleConfig(p.ClientKey, p.Secret, p.CallbackURL), p.Client()
+	case *gplus.Provider:
+		cfg, client = googleConfig(p.ClientKey, p.Secret, p.CallbackURL), p.Client()
+	}
+	if s, ok := sess.(*OIDCSession); ok {
+		if _, err := s.AuthorizeContext(ctx, provider, params); err != nil {
+			return nil, err
+		}
+		return strings.Fields(s.Scope), nil
+	}
+	if cfg == nil {
+		_, err := sess.Authorize(provider, params)
+		return nil, err
 	}
 
-	// get new token and retry fetch
-	_, err = sess.Authorize(provider, req.URL.Query())
+	token, err := cfg.Exchange(context.WithValue(ctx, oauth2.HTTPClient, client), params.Get("code"))
 	if err != nil {
-		return goth.User{}, err
+		return nil, err
+	}
+	if !token.Valid() {
+		return nil, errors.New("invalid token received from provider")
+	}
+	switch s := sess.(type) {
+	case *google.Session:
+		s.AccessToken, s.RefreshToken, s.ExpiresAt = token.AccessToken, token.RefreshToken, token.Expiry
+	case *gplus.Session:
+		s.AccessToken, s.RefreshToken, s.ExpiresAt = token.AccessToken, token.RefreshToken, token.Expiry
+	default:
+		return nil, fmt.Errorf("unexpected session %T of provider %s", sess, provider.Name())
 	}
 
-	err = storeInSession(providerName, sess.Marshal(), req, res)
+	scope, _ := token.Extra("scope").(string)
+	if scope == "" {
+		return nil, nil
+	}
+	return strings.Fields(scope), nil
+}
 
+func googleConfig(key, secret, callbackURL string) *oauth2.Config {
+	return &oauth2.Config{ClientID: key, ClientSecret: secret, RedirectURL: callbackURL, Endpoint: google.Endpoint}
+}
+
+func fetchUser(req *http.Request, provider goth.Provider, sess goth.Session) (goth.User, error) {
+	_, span := startSpan(req, "login.fetch_user", provider.Name())
+	var user goth.User
+	err := withContext(req.Context(), func() (err error) {
+		user, err = provider.FetchUser(sess)
+		return err
+	})
+	endSpan(span, "success", err)
 	if err != nil {
-		return goth.User{}, err
+		// the call may still be running, don't touch its result
+		return goth.User{}, fmt.Errorf("can't fetch user: %w", err)
 	}
 
-	gu, err := provider.FetchUser(sess)
-	return gu, err
+	debugf(req, "%s: fetched user %s", provider.Name(), user.Email)
+	return user, nil
+}
+
+// loadAuthSession restores provider session saved before redirect to the provider.
+// With signed state the session can be recreated from the callback request alone.
+func loadAuthSession(provider goth.Provider, providerName string, req *http.Request) (goth.Session, error) {
+	value, err := getFromSession(providerName, req)
+	if err != nil {
+		if stateSecret == nil || getState(req) == "" {
+			return nil, err
+		}
+
+		if _, err := verifyState(getState(req)); err != nil {
+			debugf(req, "%s: signed state %s rejected, %s", providerName, redact(getState(req)), err.Error())
+			return nil, err
+		}
+		if err := checkStateBinding(req, getState(req)); err != nil {
+			debugf(req, "%s: signed state %s rejected, %s", providerName, redact(getState(req)), err.Error())
+			return nil, err
+		}
+		debugf(req, "%s: session restored from signed state %s", providerName, redact(getState(req)))
+		var sess goth.Session
+		state := getState(req)
+		err = withContext(req.Context(), func() (err error) {
+			sess, err = provider.BeginAuth(state)
+			return err
+		})
+		if err != nil {
+			// the call may still be running, don't touch its result
+			return nil, err
+		}
+		return sess, nil
+	}
+
+	sess, err := provider.UnmarshalSession(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: corrupt session data: %w", ErrSessionMissing, err)
+	}
+
+	err = validateState(req, sess)
+	if err != nil {
+		debugf(req, "%s: state %s rejected, %s", providerName, redact(getState(req)), err.Error())
+		return nil, err
+	}
+
+	return sess, nil
 }
 
 // validateState ensures that the state token param from the original
@@ -185,70 +386,71 @@ func validateState(req *http.Request, sess goth.Session) error {
 
 	originalState := authURL.Query().Get("state")
 	if originalState != "" && (originalState != req.URL.Query().Get("state")) {
-		return errors.New("state token mismatch")
+		return ErrStateMismatch
+	}
+	if originalState != "" && stateSecret != nil {
+		if _, err := verifyState(originalState); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-// Logout invalidates a user session.
-func logout(res http.ResponseWriter, req *http.Request, name string) error {
-	session := store.Load(req)
+// Logout removes session data of the named provider.
+func Logout(res http.ResponseWriter, req *http.Request, name string) error {
+	session := loadSession(req)
 
 	err := session.Remove(res, name)
 
 	if err != nil {
-		return errors.New("Could not delete user session ")
+		return fmt.Errorf("could not delete user session: %w", err)
 	}
 
 	return nil
 }
 
 func storeInSession(key string, value string, req *http.Request, res http.ResponseWriter) error {
-	session := store.Load(req)
-	return updateSessionValue(res, session, key, value)
+	session := loadSession(req)
+	err := updateSessionValue(res, session, key, value)
+	if err != nil {
+		debugf(req, "%s: session write failed, %s", key, err.Error())
+	} else {
+		debugf(req, "%s: session written, %d bytes", key, len(value))
+	}
+	return err
 }
 
 func getFromSession(key string, req *http.Request) (string, error) {
-	session := store.Load(req)
+	session := loadSession(req)
 	value, err := getSessionValue(session, key)
 	if err != nil {
-		log.Print(err.Error())
-		return "", errors.New("could not find a matching session for this request")
+		debugf(req, "%s: session read failed, %s", key, err.Error())
+		if errors.Is(err, ErrSessionMissing) {
+			return "", err
+		}
+		return "", fmt.Errorf("%w: %w", ErrSessionMissing, err)
 	}
 
 	return value, nil
 }
 
-func getSessionValue(session *scs.Session, key string) (string, error) {
+// getSessionValue decodes provider session data, corrupted or oversized data
+// is reported as ErrSessionMissing, so the user is asked to log in again
+func getSessionValue(session *loginSession, key string) (string, error) {
 	value, err := session.GetBytes(key)
 	if err != nil {
-		return "", fmt.Errorf("could not find a matching session for this request")
-	}
-	rdata := strings.NewReader(string(value))
-	r, err := gzip.NewReader(rdata)
-	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%w: %w", ErrSessionMissing, err)
 	}
-	s, err := ioutil.ReadAll(r)
-	if err != nil {
-		return "", err
+	if len(value) == 0 {
+		return "", ErrSessionMissing
 	}
-
-	return string(s), nil
+	return decodeValue(value)
 }
 
-func updateSessionValue(w http.ResponseWriter, session *scs.Session, key, value string) error {
-	var b bytes.Buffer
-	gz := gzip.NewWriter(&b)
-	if _, err := gz.Write([]byte(value)); err != nil {
-		return err
-	}
-	if err := gz.Flush(); err != nil {
-		return err
-	}
-	if err := gz.Close(); err != nil {
+func updateSessionValue(w http.ResponseWriter, session *loginSession, key, value string) error {
+	b, err := encodeValue(value)
+	if err != nil {
 		return err
 	}
-
-	return session.PutBytes(w, key, b.Bytes())
+	return session.PutBytes(w, key, b)
 }
@@ -0,0 +1,47 @@
+package login
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Protect wraps the handler, so it is available only to authenticated users allowed by the check,
+// email of the user is added to the request context. Unauthenticated users are redirected
+// to the login route and returned back after login, others see the denied page.
+// Api calls receive 401 and 403 JSON responses instead.
+// Check can be nil to allow all authenticated users
+func Protect(next http.Handler, allow func(email string) bool) http.Handler {
+	return Middleware(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		email := EmailFromContext(req.Context())
+		if email == "" {
+			authMetrics.observeDenial("protect", "unauthenticated")
+			if wantsJSON(req) {
+				writeJSON(res, http.StatusUnauthorized, map[string]interface{}{
+					"status": "unauthorized",
+					"login":  loginRoute,
+				})
+				return
+			}
+			redirect(res, LoginURL(loginRoute, req))
+			return
+		}
+		if allow != nil && !allow(email) {
+			authMetrics.observeDenial("protect", "forbidden")
+			renderDenied(res, req, email)
+			return
+		}
+
+		next.ServeHTTP(res, req)
+	}))
+}
+
+// RequireAuthenticated wraps the handler, so it is available to any user with an authenticated session,
+// the user is not checked further
+func RequireAuthenticated(next http.Handler) http.Handler {
+	return Protect(next, nil)
+}
+
+// normalizeEmail returns the form of the email used for comparisons
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,57 @@
+package login
+
+import (
+	"net/http"
+
+	"github.com/markbates/goth"
+)
+
+const guestKey = "login:guest"
+
+var upgradeHooks []func(req *http.Request, guestID string, user goth.User)
+
+// AllowGuests wraps the handler, so visitors without login get a guest identity kept in the session.
+// Data saved in the session by guests stays there after login, use OnUpgrade to move data
+// kept elsewhere (e.g. a cart in the database) to the account
+func AllowGuests(next http.Handler) http.Handler {
+	return Middleware(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if EmailFromContext(req.Context()) == "" && GuestID(req) == "" {
+			session := loadSession(req)
+			if err := session.PutString(res, guestKey, newNonce()); err != nil {
+				reportError(req, err)
+			}
+		}
+		next.ServeHTTP(res, req)
+	}))
+}
+
+// GuestID returns identity of the guest, it is empty for authenticated users and
+// for visitors who haven't opened pages wrapped by AllowGuests
+func GuestID(req *http.Request) string {
+	id, _ := loadSession(req).GetString(guestKey)
+	return id
+}
+
+// IsGuest checks that the visitor uses guest access
+func IsGuest(req *http.Request) bool {
+	return GuestID(req) != "" && GetEmail(req) == ""
+}
+
+// OnUpgrade registers a function called when a guest logs in, before the guest identity is removed
+func OnUpgrade(hook func(req *http.Request, guestID string, user goth.User)) {
+	upgradeHooks = append(upgradeHooks, hook)
+}
+
+// upgradeGuest passes the guest identity to hooks and removes it, the rest of the session is kept
+func upgradeGuest(res http.ResponseWriter, req *http.Request, user goth.User) error {
+	id := GuestID(req)
+	if id == "" {
+		return nil
+	}
+
+	for _, hook := range upgradeHooks {
+		hook(req, id, user)
+	}
+	debugf(req, "guest upgraded to %s", user.Email)
+	return loadSession(req).Remove(res, guestKey)
+}
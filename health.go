@@ -0,0 +1,124 @@
+package login
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alexedwards/scs"
+	"github.com/markbates/goth/providers/google"
+	"github.com/markbates/goth/providers/gplus"
+)
+
+// healthTimeout limits each check, so the probe answers before its own timeout
+const healthTimeout = 5 * time.Second
+
+// healthClient returns the client of SetHTTPClient without redirects and with the timeout of checks
+func healthClient() *http.Client {
+	client := *providerClient()
+	if client.Timeout == 0 || client.Timeout > healthTimeout {
+		client.Timeout = healthTimeout
+	}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &client
+}
+
+type readiness struct {
+	sync.Mutex
+	store   scs.Store
+	ttl     time.Duration
+	checked time.Time
+	status  map[string]string
+	ready   bool
+}
+
+// ReadinessHandler returns handler for readiness probes. It checks connectivity of the session
+// store (nil to skip) and reachability of the auth endpoints of configured providers,
+// results are cached for ttl. Not ready state is reported with 503 status
+func ReadinessHandler(backend scs.Store, ttl time.Duration) http.Handler {
+	r := &readiness{store: backend, ttl: ttl}
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ready, status := r.check()
+
+		res.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if !ready {
+			res.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(res).Encode(map[string]interface{}{
+			"ready":  ready,
+			"checks": status,
+		})
+	})
+}
+
+func (r *readiness) check() (bool, map[string]string) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.status != nil && time.Since(r.checked) < r.ttl {
+		return r.ready, r.status
+	}
+
+	r.ready = true
+	r.status = make(map[string]string)
+
+	if r.store != nil {
+		r.report("session", checkStore(r.store))
+	}
+	for _, name := range enabledProviders {
+		if url := providerEndpoint(name); url != "" {
+			r.report("provider:"+name, checkEndpoint(url))
+		} else {
+			r.status["provider:"+name] = "not checked"
+		}
+	}
+
+	r.checked = time.Now()
+	return r.ready, r.status
+}
+
+func (r *readiness) report(name string, err error) {
+	if err != nil {
+		r.ready = false
+		r.status[name] = err.Error()
+		logger.Errorf("Readiness check %s failed, %s", name, err.Error())
+	} else {
+		r.status[name] = "ok"
+	}
+}
+
+func checkStore(backend scs.Store) error {
+	_, _, err := backend.Find("login-readiness-probe")
+	return err
+}
+
+// providerEndpoint returns the discovery document of the provider, or an empty string
+// for providers which have none the package knows
+func providerEndpoint(name string) string {
+	switch p := providers[name].(type) {
+	case *OIDCProvider:
+		return p.keys.discoveryURL
+	case *google.Provider, *gplus.Provider:
+		return GoogleDiscoveryURL
+	}
+	return ""
+}
+
+// checkEndpoint requests the url, the provider is reachable when it doesn't respond with an error
+func checkEndpoint(url string) error {
+	res, err := healthClient().Get(url)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("auth endpoint responded with status %d", res.StatusCode)
+	}
+	return nil
+}
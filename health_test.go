@@ -0,0 +1,44 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingTransport struct{ requests atomic.Int32 }
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestReadinessUsesHTTPClient(t *testing.T) {
+	iss := newIssuer(t)
+	transport := &countingTransport{}
+	previous := enabledProviders
+	enabledProviders = nil
+	SetHTTPClient(&http.Client{Transport: transport})
+	AddProvider(NewOIDCProvider(iss.URL, "client", "secret", "/callback"))
+	defer func() {
+		removeProvider("oidc")
+		enabledProviders = previous
+		SetHTTPClient(nil)
+	}()
+
+	rec := httptest.NewRecorder()
+	ReadinessHandler(nil, time.Minute).ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+	var body struct {
+		Ready  bool              `json:"ready"`
+		Checks map[string]string `json:"checks"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if !body.Ready || body.Checks["provider:oidc"] != "ok" || transport.requests.Load() != 1 {
+		t.Errorf("readiness is %+v after %d requests of the client", body, transport.requests.Load())
+	}
+}
@@ -0,0 +1,189 @@
+package login
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Messages contains texts of built-in pages and errors
+type Messages map[string]string
+
+// keys of built-in messages
+const (
+	msgStartFailed    = "start_failed"
+	msgCompleteFailed = "complete_failed"
+	msgAuthFailed     = "auth_failed"
+	msgDeclined       = "declined"
+	msgSessionExpired = "session_expired"
+	msgInvalidEmail   = "invalid_email"
+	msgSendFailed     = "send_failed"
+	msgBadCredentials = "bad_credentials"
+	msgWeakPassword   = "weak_password"
+	msgUnknownAccount = "unknown_account"
+	msgMaintenance    = "maintenance"
+)
+
+var defaultLanguage = "en"
+
+var catalog = map[string]Messages{
+	"en": {
+		"start_failed":       "Can't start user's authentication",
+		"complete_failed":    "Can't complete user's authentication",
+		"auth_failed":        "Authentication failed",
+		"declined":           "Access to your account was not granted.",
+		"session_expired":    "Your login session has expired, please log in again.",
+		"invalid_email":      "Please enter a valid email address.",
+		"send_failed":        "Can't send the login link, please try again later.",
+		"bad_credentials":    "Invalid email or password.",
+		"weak_password":      "The password is too short.",
+		"unknown_account":    "This account is not signed in on this device.",
+		"try_again":          "Try again",
+		"denied_title":       "Access denied",
+		"denied_text":        "%s doesn't have access to this application.",
+		"switch_account":     "Sign in with another account",
+		"sign_in":            "Sign in",
+		"sign_in_with":       "Sign in with %s",
+		"terms_title":        "Terms of service",
+		"terms_text":         "Please accept the terms of service to continue.",
+		"terms_link":         "Read the terms",
+		"terms_accept":       "Accept",
+		"captcha_title":      "Confirm you are not a robot",
+		"captcha_text":       "Many sign-in attempts came from your network, please solve the challenge to continue.",
+		"captcha_submit":     "Continue",
+		"captcha_password":   "Password",
+		"maintenance":        "Login is temporarily unavailable due to maintenance.",
+		"maintenance_title":  "Maintenance",
+		"maintenance_text":   "The application is under maintenance, please try again later.",
+		"revoke_title":       "Sign out the session",
+		"revoke_text":        "Was it not you who signed in as %s? Sign out that session and change the password at your provider.",
+		"revoke_confirm":     "Sign out",
+		"email_link_title":   "Sign in",
+		"email_link_text":    "Sign in as %s?",
+		"email_link_confirm": "Sign in",
+	},
+	"de": {
+		"start_failed":       "Die Anmeldung konnte nicht gestartet werden",
+		"complete_failed":    "Die Anmeldung konnte nicht abgeschlossen werden",
+		"auth_failed":        "Anmeldung fehlgeschlagen",
+		"declined":           "Der Zugriff auf Ihr Konto wurde nicht gewährt.",
+		"session_expired":    "Ihre Anmeldesitzung ist abgelaufen, bitte melden Sie sich erneut an.",
+		"invalid_email":      "Bitte geben Sie eine gültige E-Mail-Adresse ein.",
+		"send_failed":        "Der Anmeldelink konnte nicht gesendet werden, bitte versuchen Sie es später erneut.",
+		"bad_credentials":    "Ungültige E-Mail-Adresse oder ungültiges Passwort.",
+		"weak_password":      "Das Passwort ist zu kurz.",
+		"unknown_account":    "Dieses Konto ist auf diesem Gerät nicht angemeldet.",
+		"try_again":          "Erneut versuchen",
+		"denied_title":       "Zugriff verweigert",
+		"denied_text":        "%s hat keinen Zugriff auf diese Anwendung.",
+		"switch_account":     "Mit einem anderen Konto anmelden",
+		"sign_in":            "Anmelden",
+		"sign_in_with":       "Mit %s anmelden",
+		"terms_title":        "Nutzungsbedingungen",
+		"terms_text":         "Bitte akzeptieren Sie die Nutzungsbedingungen, um fortzufahren.",
+		"terms_link":         "Nutzungsbedingungen lesen",
+		"terms_accept":       "Akzeptieren",
+		"captcha_title":      "Bestätigen Sie, dass Sie kein Roboter sind",
+		"captcha_text":       "Aus Ihrem Netzwerk kamen viele Anmeldeversuche, bitte lösen Sie die Aufgabe, um fortzufahren.",
+		"captcha_submit":     "Weiter",
+		"captcha_password":   "Passwort",
+		"maintenance":        "Die Anmeldung ist wegen Wartungsarbeiten vorübergehend nicht möglich.",
+		"maintenance_title":  "Wartung",
+		"maintenance_text":   "Die Anwendung wird gerade gewartet, bitte versuchen Sie es später erneut.",
+		"revoke_title":       "Sitzung abmelden",
+		"revoke_text":        "Haben nicht Sie sich als %s angemeldet? Melden Sie diese Sitzung ab und ändern Sie das Passwort bei Ihrem Anbieter.",
+		"revoke_confirm":     "Abmelden",
+		"email_link_title":   "Anmelden",
+		"email_link_text":    "Als %s anmelden?",
+		"email_link_confirm": "Anmelden",
+	},
+	"ru": {
+		"start_failed":       "Не удалось начать авторизацию",
+		"complete_failed":    "Не удалось завершить авторизацию",
+		"auth_failed":        "Ошибка авторизации",
+		"declined":           "Доступ к учётной записи не был предоставлен.",
+		"session_expired":    "Сессия входа истекла, пожалуйста, войдите снова.",
+		"invalid_email":      "Пожалуйста, введите корректный адрес почты.",
+		"send_failed":        "Не удалось отправить ссылку для входа, попробуйте позже.",
+		"bad_credentials":    "Неверный адрес почты или пароль.",
+		"weak_password":      "Пароль слишком короткий.",
+		"unknown_account":    "Эта учётная запись не авторизована на этом устройстве.",
+		"try_again":          "Попробовать снова",
+		"denied_title":       "Доступ запрещён",
+		"denied_text":        "У %s нет доступа к этому приложению.",
+		"switch_account":     "Войти с другой учётной записью",
+		"sign_in":            "Вход",
+		"sign_in_with":       "Войти через %s",
+		"terms_title":        "Условия использования",
+		"terms_text":         "Чтобы продолжить, примите условия использования.",
+		"terms_link":         "Прочитать условия",
+		"terms_accept":       "Принять",
+		"captcha_title":      "Подтвердите, что вы не робот",
+		"captcha_text":       "Из вашей сети было много попыток входа, чтобы продолжить, пройдите проверку.",
+		"captcha_submit":     "Продолжить",
+		"captcha_password":   "Пароль",
+		"maintenance":        "Вход временно недоступен из-за технических работ.",
+		"maintenance_title":  "Технические работы",
+		"maintenance_text":   "В приложении проводятся технические работы, попробуйте позже.",
+		"revoke_title":       "Завершить сессию",
+		"revoke_text":        "Это были не вы, кто вошёл как %s? Завершите эту сессию и смените пароль у вашего провайдера.",
+		"revoke_confirm":     "Завершить",
+		"email_link_title":   "Вход",
+		"email_link_text":    "Войти как %s?",
+		"email_link_confirm": "Войти",
+	},
+}
+
+// SetMessages adds or overrides translations for the language
+func SetMessages(lang string, messages Messages) {
+	lang = strings.ToLower(lang)
+	if catalog[lang] == nil {
+		catalog[lang] = Messages{}
+	}
+	for key, text := range messages {
+		catalog[lang][key] = text
+	}
+}
+
+// SetDefaultLanguage defines language used when none of the accepted languages is available
+func SetDefaultLanguage(lang string) {
+	defaultLanguage = strings.ToLower(lang)
+}
+
+// messagesFor returns messages in the language preferred by the request,
+// missing translations fall back to the default language and to english
+func messagesFor(req *http.Request) Messages {
+	lang := negotiateLanguage(req.Header.Get("Accept-Language"))
+
+	result := Messages{}
+	for _, l := range []string{"en", defaultLanguage, lang} {
+		for key, text := range catalog[l] {
+			result[key] = text
+		}
+	}
+	return result
+}
+
+// negotiateLanguage picks the first available language of Accept-Language header,
+// quality values are ignored as browsers send languages in preferred order
+func negotiateLanguage(header string) string {
+	for _, part := range strings.Split(header, ",") {
+		tag := strings.ToLower(strings.TrimSpace(strings.Split(part, ";")[0]))
+		if _, ok := catalog[tag]; ok {
+			return tag
+		}
+		if i := strings.Index(tag, "-"); i > 0 {
+			if _, ok := catalog[tag[:i]]; ok {
+				return tag[:i]
+			}
+		}
+	}
+	return defaultLanguage
+}
+
+// translate returns text of the message in the language preferred by the request
+func translate(req *http.Request, key string) string {
+	if text, ok := messagesFor(req)[key]; ok {
+		return text
+	}
+	return key
+}
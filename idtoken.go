@@ -0,0 +1,128 @@
+package login
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// IDClaims contains claims of OpenID Connect id token used by the package
+type IDClaims struct {
+	Issuer        string   `json:"iss"`
+	Audience      audience `json:"aud"`
+	Subject       string   `json:"sub"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+	Picture       string   `json:"picture"`
+	HostedDomain  string   `json:"hd"`
+	Nonce         string   `json:"nonce"`
+	IssuedAt      int64    `json:"iat"`
+	NotBefore     int64    `json:"nbf"`
+	Expires       int64    `json:"exp"`
+	// AuthTime is when the user authenticated at the issuer, issuers send it on max_age requests
+	AuthTime int64 `json:"auth_time"`
+	// Raw contains all claims of the token
+	Raw map[string]interface{} `json:"-"`
+}
+
+// audience is a string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyIDToken checks RS256 signature of the token with keys of the cache, its issuer,
+// audience, validity period and expiry, and returns the claims. Errors wrap ErrInvalidToken
+func VerifyIDToken(keys *KeyCache, token, clientID string) (IDClaims, error) {
+	var claims IDClaims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return claims, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	if header.Alg != "RS256" {
+		return claims, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	key, err := keys.Key(header.Kid)
+	if err != nil {
+		return claims, fmt.Errorf("%w: %s", ErrInvalidToken, err.Error())
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], signature); err != nil {
+		return claims, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return claims, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	if err := decodeSegment(parts[1], &claims.Raw); err != nil {
+		return claims, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+
+	discovery, err := keys.Discovery()
+	if err != nil {
+		return claims, fmt.Errorf("%w: %s", ErrInvalidToken, err.Error())
+	}
+	// Google issues tokens with and without the scheme
+	if claims.Issuer != discovery.Issuer && "https://"+claims.Issuer != discovery.Issuer {
+		return claims, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, claims.Issuer)
+	}
+	if !claims.Audience.contains(clientID) {
+		return claims, fmt.Errorf("%w: token is issued for another client", ErrInvalidToken)
+	}
+	var notBefore time.Time
+	if claims.NotBefore != 0 {
+		notBefore = time.Unix(claims.NotBefore, 0)
+	}
+	if err := checkTimestamps(time.Unix(claims.IssuedAt, 0), notBefore, time.Unix(claims.Expires, 0)); err != nil {
+		return claims, fmt.Errorf("%w: %s", ErrInvalidToken, err.Error())
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,143 @@
+package login
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// issuer serves discovery document and signing key, and signs tokens with the key
+type issuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+}
+
+func newIssuer(t *testing.T) *issuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := &issuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(res http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(res).Encode(Discovery{Issuer: i.URL, JWKSURI: i.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(res http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(res).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "test",
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	i.Server = httptest.NewServer(mux)
+	t.Cleanup(i.Close)
+	return i
+}
+
+func (i *issuer) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyIDTokenTimestamps(t *testing.T) {
+	iss := newIssuer(t)
+	keys := NewKeyCache(iss.URL+"/.well-known/openid-configuration", time.Hour)
+	now := time.Now()
+
+	cases := []struct {
+		name   string
+		claims map[string]interface{}
+		valid  bool
+	}{
+		{"valid", map[string]interface{}{}, true},
+		{"not before in skew", map[string]interface{}{"nbf": now.Add(30 * time.Second).Unix()}, true},
+		{"not yet valid", map[string]interface{}{"nbf": now.Add(time.Hour).Unix()}, false},
+		{"issued in future", map[string]interface{}{"iat": now.Add(time.Hour).Unix()}, false},
+		{"expired", map[string]interface{}{"exp": now.Add(-time.Hour).Unix()}, false},
+	}
+	for _, c := range cases {
+		claims := map[string]interface{}{
+			"iss": iss.URL, "aud": "client", "sub": "1", "email": "user@example.com",
+			"iat": now.Unix(), "exp": now.Add(time.Hour).Unix(),
+		}
+		for k, v := range c.claims {
+			claims[k] = v
+		}
+
+		_, err := VerifyIDToken(keys, iss.sign(t, claims), "client")
+		if c.valid && err != nil {
+			t.Errorf("%s: %s", c.name, err)
+		}
+		if !c.valid && !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: token is accepted, %v", c.name, err)
+		}
+	}
+}
+
+func TestKeyCacheRefresh(t *testing.T) {
+	var fetches, failing atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/keys" {
+			fetches.Add(1)
+			time.Sleep(20 * time.Millisecond)
+			json.NewEncoder(res).Encode(map[string]interface{}{"keys": []interface{}{}})
+			return
+		}
+		if failing.Load() != 0 {
+			res.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(res).Encode(Discovery{Issuer: server.URL, JWKSURI: server.URL + "/keys"})
+	}))
+	defer server.Close()
+	keys := NewKeyCache(server.URL, time.Hour)
+
+	// concurrent lookups of unknown keys share one fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keys.Key("rotated")
+		}()
+	}
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("keys are fetched %d times", n)
+	}
+
+	// failed fetch counts as an attempt, the next unknown key doesn't hit the provider again
+	keys.mu.Lock()
+	keys.attempted = time.Now().Add(-2 * minKeyRefresh)
+	keys.mu.Unlock()
+	failing.Store(1)
+	if _, err := keys.Key("other"); err == nil {
+		t.Error("key is found while the provider fails")
+	}
+	keys.mu.Lock()
+	attempted := keys.attempted
+	keys.mu.Unlock()
+	if time.Since(attempted) > time.Second {
+		t.Error("failed fetch is not recorded")
+	}
+}
@@ -0,0 +1,283 @@
+package login_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs"
+	"github.com/alexedwards/scs/stores/memstore"
+	"github.com/mkozhukh/login"
+	"github.com/mkozhukh/login/shardstore"
+)
+
+// instanceStoreEnv passes url of the shared store to the second instance
+const instanceStoreEnv = "LOGIN_TEST_SHARED_STORE"
+
+// TestMultiInstance runs the second instance in another process, so nothing can be shared
+// through globals, the instances have only the store in common
+func TestMultiInstance(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a second process")
+	}
+	shared := httptest.NewServer(storeHandler(shardstore.New(0, 0)))
+	defer shared.Close()
+
+	mailer := &linkMailer{}
+	a := httptest.NewServer(newInstance(remoteStore(shared.URL), mailer))
+	defer a.Close()
+	defer resetInstance()
+	b := startInstance(t, shared.URL)
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	res, err := client.PostForm(a.URL+"/login/email", url.Values{"email": {"user@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	link, err := url.Parse(mailer.Link())
+	if err != nil || link.Query().Get("token") == "" {
+		t.Fatalf("no link is sent, %v", err)
+	}
+	token := url.Values{"token": {link.Query().Get("token")}}
+
+	// the link of instance A is confirmed at instance B
+	res, err = client.PostForm(b+"/login/email/verify", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "/app" {
+		t.Fatalf("instance B: login ends with %d %q", res.StatusCode, res.Header.Get("Location"))
+	}
+
+	// the session of instance B is known to A, cookies are not bound to ports
+	res, err = client.Get(a.URL + "/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(body) != "user@example.com" {
+		t.Fatalf("instance A: /app returned %d %q", res.StatusCode, body)
+	}
+
+	// the token consumed by B can't be used at A
+	res, err = client.PostForm(a.URL+"/login/email/verify", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusSeeOther {
+		t.Fatal("instance A accepted the link used at instance B")
+	}
+
+	// attempts at both instances are counted together, the limit is 3 per minute
+	for i, target := range []string{a.URL, b, b} {
+		res, err = client.PostForm(target+"/login/email", url.Values{"email": {"user@example.com"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if challenged := res.StatusCode == http.StatusTooManyRequests; challenged != (i == 2) {
+			t.Fatalf("attempt %d: unexpected status %d", i+2, res.StatusCode)
+		}
+	}
+}
+
+// TestInstanceProcess is the second instance started by TestMultiInstance, it prints its url
+// and serves until stdin is closed
+func TestInstanceProcess(t *testing.T) {
+	storeURL := os.Getenv(instanceStoreEnv)
+	if storeURL == "" {
+		t.Skip("runs as the second instance of TestMultiInstance")
+	}
+	srv := httptest.NewServer(newInstance(remoteStore(storeURL), &linkMailer{}))
+	defer srv.Close()
+	os.Stdout.WriteString(srv.URL + "\n")
+	io.Copy(io.Discard, os.Stdin)
+}
+
+func startInstance(t *testing.T, storeURL string) string {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestInstanceProcess$")
+	cmd.Env = append(os.Environ(), instanceStoreEnv+"="+storeURL)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		stdin.Close()
+		cmd.Wait()
+	})
+
+	lines := bufio.NewScanner(stdout)
+	for lines.Scan() {
+		if strings.HasPrefix(lines.Text(), "http://") {
+			go io.Copy(io.Discard, stdout)
+			return lines.Text()
+		}
+	}
+	t.Fatal("second instance didn't start")
+	return ""
+}
+
+// newInstance configures the package as each instance of the application does
+func newInstance(store scs.Store, mailer *linkMailer) http.Handler {
+	login.SetSession(scs.NewManager(store))
+	login.SetMultiInstance(store, []byte("state secret shared by instances"))
+	login.SetCaptcha(rejectingCaptcha{}, 3, time.Minute)
+
+	r := &router{ServeMux: http.NewServeMux()}
+	err := login.SetMagicLink(r, "/login/email", "/login/email/verify", login.MagicLinkConfig{
+		Secret: []byte("link secret shared by instances"),
+		Mailer: mailer,
+	}, appHandler{})
+	if err != nil {
+		panic(err)
+	}
+	r.Handle("GET /app", login.RequireAuthenticated(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(login.GetEmail(req)))
+	})))
+	return r
+}
+
+// resetInstance restores defaults for other tests of the package
+func resetInstance() {
+	login.SetCaptcha(nil, 0, time.Minute)
+	login.SetStateSecret(nil, 0)
+	login.SetUsedStateStore(memstore.New(time.Minute))
+}
+
+type router struct{ *http.ServeMux }
+
+func (r *router) Get(pattern string, fn http.HandlerFunc)  { r.HandleFunc("GET "+pattern, fn) }
+func (r *router) Post(pattern string, fn http.HandlerFunc) { r.HandleFunc("POST "+pattern, fn) }
+
+type appHandler struct{}
+
+func (appHandler) Login(req *http.Request, res http.ResponseWriter, email string) string {
+	return "/app"
+}
+func (appHandler) Logout(req *http.Request, res http.ResponseWriter) string { return "/" }
+
+type linkMailer struct {
+	mu   sync.Mutex
+	link string
+}
+
+func (m *linkMailer) SendLink(ctx context.Context, email, link string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.link = link
+	return nil
+}
+
+func (m *linkMailer) Link() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.link
+}
+
+type rejectingCaptcha struct{}
+
+func (rejectingCaptcha) Widget(nonce string) template.HTML { return "" }
+func (rejectingCaptcha) Verify(req *http.Request) error    { return errors.New("captcha is not solved") }
+
+// storeHandler serves the store over http, as a Redis server would be shared by instances
+func storeHandler(store *shardstore.ShardStore) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		key := req.URL.Query().Get("key")
+		expiry, _ := strconv.ParseInt(req.URL.Query().Get("expiry"), 10, 64)
+		data, _ := io.ReadAll(req.Body)
+
+		switch req.Method {
+		case http.MethodGet:
+			value, found, _ := store.Find(key)
+			if !found {
+				res.WriteHeader(http.StatusNotFound)
+				return
+			}
+			res.Write(value)
+		case http.MethodPut:
+			store.Save(key, data, time.Unix(0, expiry))
+		case http.MethodPost:
+			if added, _ := store.Add(key, data, time.Unix(0, expiry)); !added {
+				res.WriteHeader(http.StatusConflict)
+			}
+		case http.MethodDelete:
+			store.Delete(key)
+		}
+	})
+}
+
+// remoteStore is the client of storeHandler
+type remoteStore string
+
+func (s remoteStore) Find(key string) ([]byte, bool, error) {
+	res, err := http.Get(s.url(key, time.Time{}))
+	if err != nil {
+		return nil, false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	data, err := io.ReadAll(res.Body)
+	return data, err == nil, err
+}
+
+func (s remoteStore) Save(key string, b []byte, expiry time.Time) error {
+	_, err := s.do(http.MethodPut, s.url(key, expiry), b)
+	return err
+}
+
+func (s remoteStore) Add(key string, b []byte, expiry time.Time) (bool, error) {
+	status, err := s.do(http.MethodPost, s.url(key, expiry), b)
+	return status == http.StatusOK, err
+}
+
+func (s remoteStore) Delete(key string) error {
+	_, err := s.do(http.MethodDelete, s.url(key, time.Time{}), nil)
+	return err
+}
+
+func (s remoteStore) url(key string, expiry time.Time) string {
+	return string(s) + "/?" + url.Values{"key": {key}, "expiry": {strconv.FormatInt(expiry.UnixNano(), 10)}}.Encode()
+}
+
+func (s remoteStore) do(method, target string, body []byte) (int, error) {
+	req, err := http.NewRequest(method, target, strings.NewReader(string(body)))
+	if err != nil {
+		return 0, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	return res.StatusCode, nil
+}
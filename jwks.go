@@ -0,0 +1,228 @@
+package login
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GoogleDiscoveryURL is the OpenID Connect discovery document of Google
+const GoogleDiscoveryURL = "https://accounts.google.com/.well-known/openid-configuration"
+
+// Discovery contains fields of OpenID Connect discovery document used by the package
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// KeyCache keeps discovery document and signing keys of the provider. Stale data is served
+// while it is refreshed in background, so verification doesn't wait for the network
+// except for the first fetch and for keys unknown after rotation
+type KeyCache struct {
+	discoveryURL string
+	ttl          time.Duration
+
+	mu        sync.Mutex
+	discovery Discovery
+	keys      map[string]*rsa.PublicKey
+	expires   time.Time
+	// attempted is the time of the last fetch, successful or not
+	attempted time.Time
+	// refreshing is the fetch in progress, concurrent callers wait for it
+	refreshing *keyFetch
+}
+
+type keyFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// minKeyRefresh limits refreshes caused by unknown key ids
+const minKeyRefresh = time.Minute
+
+// NewKeyCache creates cache of the discovery document at the url, ttl is used when
+// the response has no Cache-Control max-age
+func NewKeyCache(discoveryURL string, ttl time.Duration) *KeyCache {
+	return &KeyCache{discoveryURL: discoveryURL, ttl: ttl}
+}
+
+// Discovery returns the discovery document
+func (c *KeyCache) Discovery() (Discovery, error) {
+	if err := c.ensure(); err != nil {
+		return Discovery{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.discovery, nil
+}
+
+// Key returns public key with the key id, unknown id causes refresh of the keys
+// at most once a minute
+func (c *KeyCache) Key(kid string) (*rsa.PublicKey, error) {
+	if err := c.ensure(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	key, ok := c.keys[kid]
+	recent := time.Since(c.attempted) < minKeyRefresh && c.refreshing == nil
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !recent {
+		if err := c.refresh(); err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		key, ok = c.keys[kid]
+		c.mu.Unlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// ensure fetches data on first use, and starts background refresh of stale data.
+// After a failed fetch stale data is served for a minute before the next attempt
+func (c *KeyCache) ensure() error {
+	c.mu.Lock()
+	empty := c.keys == nil
+	stale := !empty && time.Now().After(c.expires) && c.refreshing == nil && time.Since(c.attempted) >= minKeyRefresh
+	c.mu.Unlock()
+
+	if empty {
+		return c.refresh()
+	}
+	if stale {
+		go func() {
+			if err := c.refresh(); err != nil {
+				logger.Errorf("Can't refresh signing keys, %s", err.Error())
+			}
+		}()
+	}
+	return nil
+}
+
+// refresh fetches the data, concurrent callers wait for the fetch in progress and share its result
+func (c *KeyCache) refresh() error {
+	c.mu.Lock()
+	if call := c.refreshing; call != nil {
+		c.mu.Unlock()
+		<-call.done
+		return call.err
+	}
+	call := &keyFetch{done: make(chan struct{})}
+	c.refreshing = call
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.attempted = time.Now()
+		c.refreshing = nil
+		c.mu.Unlock()
+		close(call.done)
+	}()
+	call.err = c.fetch()
+	return call.err
+}
+
+func (c *KeyCache) fetch() error {
+	var discovery Discovery
+	maxAge, err := fetchJSON(c.discoveryURL, &discovery)
+	if err != nil {
+		return fmt.Errorf("can't fetch discovery document: %w", err)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	keysAge, err := fetchJSON(discovery.JWKSURI, &set)
+	if err != nil {
+		return fmt.Errorf("can't fetch signing keys: %w", err)
+	}
+	if keysAge > 0 && (maxAge == 0 || keysAge < maxAge) {
+		maxAge = keysAge
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		key, err := rsaKey(k.N, k.E)
+		if err != nil {
+			return fmt.Errorf("invalid signing key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+
+	if maxAge <= 0 {
+		maxAge = c.ttl
+	}
+	c.mu.Lock()
+	c.discovery = discovery
+	c.keys = keys
+	c.expires = time.Now().Add(maxAge)
+	c.mu.Unlock()
+	return nil
+}
+
+// fetchJSON decodes the response and returns its Cache-Control max-age, or zero
+func fetchJSON(url string, v interface{}) (time.Duration, error) {
+	client := httpClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	res, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return 0, err
+	}
+
+	for _, directive := range strings.Split(res.Header.Get("Cache-Control"), ",") {
+		directive = strings.TrimSpace(directive)
+		if strings.HasPrefix(directive, "max-age=") {
+			if seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil {
+				return time.Duration(seconds) * time.Second, nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+func rsaKey(n, e string) (*rsa.PublicKey, error) {
+	nb, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, err
+	}
+	eb, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(new(big.Int).SetBytes(eb).Int64())}, nil
+}
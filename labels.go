@@ -0,0 +1,17 @@
+package login
+
+import (
+	"context"
+	"net/http"
+	"runtime/pprof"
+)
+
+// labeled runs the handler with pprof labels of the route, so cpu and goroutine profiles
+// attribute time spent in authentication. Goroutines of provider calls inherit the labels
+func labeled(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		pprof.Do(req.Context(), pprof.Labels("login_route", route), func(ctx context.Context) {
+			handler(res, req.WithContext(ctx))
+		})
+	}
+}
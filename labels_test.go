@@ -0,0 +1,64 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexedwards/scs"
+	"github.com/alexedwards/scs/stores/memstore"
+	"github.com/markbates/goth"
+)
+
+func BenchmarkLabeled(b *testing.B) {
+	handler := func(res http.ResponseWriter, req *http.Request) {}
+	req := httptest.NewRequest("GET", "/login", nil)
+
+	b.Run("bare", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			handler(httptest.NewRecorder(), req)
+		}
+	})
+	b.Run("labeled", func(b *testing.B) {
+		h := labeled("login", handler)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			h(httptest.NewRecorder(), req)
+		}
+	})
+}
+
+// BenchmarkProtect measures the guard of each request with a session, with and without labels
+func BenchmarkProtect(b *testing.B) {
+	manager := scs.NewManager(memstore.New(0))
+	SetSession(manager)
+
+	rec := httptest.NewRecorder()
+	if err := SignIn(rec, httptest.NewRequest("GET", "/", nil), goth.User{Email: "user@example.com", Provider: "google"}); err != nil {
+		b.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/app", nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+
+	protected := manager.Use(Protect(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if EmailFromContext(req.Context()) == "" {
+			b.Fatal("session is not loaded")
+		}
+	}), nil))
+	b.Run("bare", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			protected.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	})
+	b.Run("labeled", func(b *testing.B) {
+		h := labeled("app", protected.ServeHTTP)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			h(httptest.NewRecorder(), req)
+		}
+	})
+}
@@ -0,0 +1,31 @@
+package login
+
+import "log"
+
+// Logger is used for all log output of the package,
+// logrus.Logger and zap.SugaredLogger can be used as is
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+var logger Logger = stdLogger{}
+
+// SetLogger defines logger
+func SetLogger(l Logger) {
+	logger = l
+}
+
+// stdLogger writes info and error messages through the standard log package
+type stdLogger struct{}
+
+func (stdLogger) Debugf(format string, args ...interface{}) {}
+
+func (stdLogger) Infof(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+func (stdLogger) Errorf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
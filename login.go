@@ -1,8 +1,12 @@
 package login
 
 import (
-	"log"
+	"fmt"
 	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/alexedwards/scs"
 	"github.com/markbates/goth"
@@ -16,43 +20,393 @@ func SetSession(session *scs.Manager) {
 type Router interface {
 	Get(pattern string, handlerFn http.HandlerFunc)
 }
+
+// HeadRouter is implemented by routers which support HEAD requests, e.g. chi.Router,
+// routes of such routers respond to HEAD probes
+type HeadRouter interface {
+	Head(pattern string, handlerFn http.HandlerFunc)
+}
+
+// PostRouter is implemented by routers which support POST requests, e.g. chi.Router,
+// forms of such routers are submitted with POST
+type PostRouter interface {
+	Post(pattern string, handlerFn http.HandlerFunc)
+}
+
 type Handler interface {
 	Login(req *http.Request, res http.ResponseWriter, email string) string
 	Logout(req *http.Request, res http.ResponseWriter) string
 }
 
+// Gateway is called after successful authentication to establish the user's session
+type Gateway func(res http.ResponseWriter, req *http.Request, user goth.User, handler Handler)
+
+var gateway Gateway = DefaultGateway
+
+// SetGateway replaces the gateway, custom gateway can run additional checks
+// and call DefaultGateway to proceed with the login. LoginSuccess event is emitted
+// by DefaultGateway once the session is saved
+func SetGateway(g Gateway) {
+	gateway = g
+}
+
+// DefaultGateway passes email of the user to Handler.Login (or the user to UserHandler.LoginUser)
+// and redirects to the returned url,
+// or to the page passed in "returnTo" parameter of the login url.
+// When Handler.Login returns an empty url, the user is denied and the denied page is shown
+func DefaultGateway(res http.ResponseWriter, req *http.Request, user goth.User, handler Handler) {
+	var url string
+	if uh, ok := handler.(UserHandler); ok {
+		url = uh.LoginUser(req, res, user)
+	} else {
+		url = handler.Login(req, res, user.Email)
+	}
+	if url == "" {
+		emitEvent(req, LoginDenied, user.Provider, user, ErrUserDenied)
+		renderDenied(res, req, user.Email)
+		return
+	}
+
+	if err := saveUser(res, req, user); err != nil {
+		handleError(res, req, msgCompleteFailed, err)
+		return
+	}
+	emitLogin(req, user)
+
+	// return the user to the page which required login
+	if returnTo := ReturnTo(req); returnTo != "" {
+		url = returnTo
+		_ = loadSession(req).Remove(res, returnToKey)
+	}
+
+	respond(res, req, url, &user)
+}
+
+// enabledProviders contains names of providers configured by SetProvider or AddProvider
+var enabledProviders []string
+
+// providers are kept apart from the global registry of goth,
+// so other users of goth in the process don't collide with them
+var providers map[string]goth.Provider
+
 // SetProvider defines auth provider
 func SetProvider(provider goth.Provider, r Router, loginURL, logoutURL, callbackURL string, handler Handler) {
-	goth.UseProviders(provider)
-	name := provider.Name()
+	AddProvider(provider)
+	SetRoutes(r, loginURL, logoutURL, callbackURL, handler, FixedProvider(provider.Name()))
+}
+
+// AddProvider registers auth provider without routes, use SetRoutes to serve several providers
+// with the same routes
+func AddProvider(provider goth.Provider) {
+	if providers == nil {
+		providers = make(map[string]goth.Provider)
+	}
+	if _, ok := providers[provider.Name()]; !ok {
+		enabledProviders = append(enabledProviders, provider.Name())
+	}
+	providers[provider.Name()] = provider
+	applyHTTPClient(provider, nil)
+}
+
+// SetRoutes adds login, logout and callback routes, provider of each request is determined by resolver
+func SetRoutes(r Router, loginURL, logoutURL, callbackURL string, handler Handler, resolver ProviderResolver) {
+	loginRoute = loginURL
+
+	addRoute(r, callbackURL, labeled("callback", recoverer(func(res http.ResponseWriter, req *http.Request) {
+		name := resolver(req)
+		if name == "" {
+			handleError(res, req, msgCompleteFailed, ErrNoProvider)
+			return
+		}
 
-	//add routes
-	r.Get(callbackURL, func(res http.ResponseWriter, req *http.Request) {
-		user, err := completeUserAuth(res, req, name)
+		start := time.Now()
+		ctx, span := startSpan(req, "login.callback", name)
+		req = req.WithContext(ctx)
+		user, err := CompleteUserAuth(res, req, name)
 		if err != nil {
-			log.Printf("Can't complete user's authentication, %s", err.Error())
+			endSpan(span, "", err)
+			authMetrics.observeLogin(name, "failure", time.Since(start))
+			emitEvent(req, LoginFailure, name, goth.User{}, err)
+			handleFailure(res, req, handler, name, err)
 			return
 		}
 
-		redirect(res, handler.Login(req, res, user.Email))
-	})
+		req, accepted := trackLogin(req)
+		completeLogin(res, req, name, user, handler)
+		endSpan(span, loginResult(*accepted), nil)
+		authMetrics.observeLogin(name, loginResult(*accepted), time.Since(start))
+	})))
+
+	addRoute(r, loginURL, labeled("login", recoverer(challenged(func(res http.ResponseWriter, req *http.Request) {
+		if err := savePopup(res, req); err != nil {
+			reportError(req, err)
+		}
+		if err := saveAppRedirect(res, req); err != nil {
+			handleError(res, req, msgStartFailed, err)
+			return
+		}
+		if devEmail != "" {
+			devLogin(res, req, handler)
+			return
+		}
+
+		name := resolver(req)
+		if name == "" {
+			if len(enabledProviders) == 0 {
+				handleError(res, req, msgStartFailed, ErrNoProvider)
+			} else {
+				renderLogin(res, req, loginURL)
+			}
+			return
+		}
+
+		ctx, span := startSpan(req, "login.begin", name)
+		// the outcome is known only when the user is logged in without the provider
+		outcome := ""
+		defer func() { endSpan(span, outcome, nil) }()
+		req = req.WithContext(ctx)
 
-	r.Get(loginURL, func(res http.ResponseWriter, req *http.Request) {
 		// try to get the user without re-authenticating
-		if user, err := completeUserAuth(res, req, name); err == nil {
-			redirect(res, handler.Login(req, res, user.Email))
+		if user, err := CompleteUserAuth(res, req, name); err == nil {
+			req, accepted := trackLogin(req)
+			completeLogin(res, req, name, user, handler)
+			outcome = loginResult(*accepted)
+			authMetrics.observeAttempt(name, outcome)
 		} else {
-			beginAuthHandler(res, req, name)
+			if err := saveReturnTo(res, req); err != nil {
+				reportError(req, err)
+			}
+			BeginAuthHandler(res, withLoginHint(req), name)
+		}
+	}))))
+
+	addRoute(r, logoutURL, labeled("logout", recoverer(func(res http.ResponseWriter, req *http.Request) {
+		names := enabledProviders
+		if name := resolver(req); name != "" {
+			names = []string{name}
+		}
+
+		// hooks receive the session before it is destroyed
+		user := GetUser(req)
+		provider := resolver(req)
+		if provider == "" {
+			provider = user.Provider
+		}
+		e := newEvent(req, LogoutSuccess, provider, user, nil)
+		e.Session = snapshotSession(req)
+		dispatchEvent(e)
+
+		for _, name := range names {
+			if err := Logout(res, req, name); err != nil {
+				reportError(req, err)
+			}
+		}
+		if err := revokeAppTokens(req); err != nil {
+			reportError(req, err)
+		}
+		if err := clearUser(res, req); err != nil {
+			reportError(req, err)
+		}
+		if user.Email != "" {
+			authMetrics.observeLogout()
 		}
-	})
+		target := logoutTarget(req, handler.Logout(req, res))
+		respond(res, req, federatedTarget(res, req, user.Provider, target), nil)
+	})))
+}
+
+// completeLogin runs BeforeLogin hooks and passes the authenticated user to the gateway
+func completeLogin(res http.ResponseWriter, req *http.Request, provider string, user goth.User, handler Handler) {
+	if !approveLogin(res, req, provider, user) {
+		return
+	}
+	continueLogin(res, req, user, handler)
+}
+
+// continueLogin runs checks of maintenance and terms before the gateway, they are steps
+// of the flow rather than wrappers of the gateway, so SetGateway keeps them
+func continueLogin(res http.ResponseWriter, req *http.Request, user goth.User, handler Handler) {
+	if !checkMaintenance(res, req, user) || !checkTerms(res, req, user) {
+		return
+	}
+	gateway(res, req, user, handler)
+}
+
+// withLoginHint passes "login_hint" parameter of the login url to the provider,
+// so users who arrive with a known email skip the account chooser
+func withLoginHint(req *http.Request) *http.Request {
+	hint := loginHint(req)
+	if hint == "" {
+		return req
+	}
+	return WithAuthParams(req, url.Values{"login_hint": {hint}})
+}
+
+// loginHint returns the email of "login_hint" parameter, other values are ignored
+func loginHint(req *http.Request) string {
+	hint := strings.TrimSpace(req.URL.Query().Get("login_hint"))
+	if hint == "" {
+		return ""
+	}
+	if address, err := mail.ParseAddress(hint); err != nil || address.Address != hint {
+		debugf(req, "login hint %q is not an email", hint)
+		return ""
+	}
+	return hint
+}
+
+// addRoute registers the handler with and without trailing slash,
+// HEAD requests are answered without running the handler, as probes must not start or end sessions
+func addRoute(r Router, pattern string, handler http.HandlerFunc) {
+	patterns := []string{pattern}
+	if strings.HasSuffix(pattern, "/") && pattern != "/" {
+		patterns = append(patterns, strings.TrimSuffix(pattern, "/"))
+	} else if !strings.HasSuffix(pattern, "/") {
+		patterns = append(patterns, pattern+"/")
+	}
+
+	handler = singleCookies(handler)
+	for _, p := range patterns {
+		r.Get(p, handler)
+		if hr, ok := r.(HeadRouter); ok {
+			hr.Head(p, func(res http.ResponseWriter, req *http.Request) {
+				res.Header().Set("Cache-Control", "no-store")
+				res.WriteHeader(http.StatusOK)
+			})
+		}
+	}
+}
+
+var logoutPage = "/"
+
+// SetLogoutPage defines page shown after logout when Handler.Logout returns an empty url
+func SetLogoutPage(url string) {
+	logoutPage = url
+}
+
+// logoutTarget returns page shown after logout, "returnTo" parameter of the logout url
+// overrides the url provided by the handler
+func logoutTarget(req *http.Request, url string) string {
+	if returnTo := localPath(req.URL.Query().Get("returnTo")); returnTo != "" {
+		return returnTo
+	}
+	if url == "" {
+		return logoutPage
+	}
+	return url
+}
+
+var jsonMode = false
+
+// SetJSONMode enables JSON responses of login, callback and logout routes instead of redirects,
+// without it JSON is returned only for requests which accept application/json
+func SetJSONMode(enabled bool) {
+	jsonMode = enabled
+}
 
-	r.Get(logoutURL, func(res http.ResponseWriter, req *http.Request) {
-		_ = logout(res, req, name)
-		redirect(res, handler.Logout(req, res))
-	})
+// respond redirects to the url, or returns it as JSON for api clients
+func respond(res http.ResponseWriter, req *http.Request, url string, user *goth.User) {
+	body := map[string]interface{}{
+		"status":   "ok",
+		"redirect": url,
+	}
+	if user != nil {
+		body["user"] = map[string]string{
+			"email":  user.Email,
+			"name":   user.Name,
+			"avatar": user.AvatarURL,
+		}
+		if redirectToApp(res, req, user.Email) {
+			return
+		}
+		// intermediate steps of the login, e.g. terms, are shown in the popup too
+		if renderPopup(res, req, http.StatusOK, "", body) {
+			return
+		}
+	}
+	if jsonMode || wantsJSON(req) {
+		writeJSON(res, http.StatusOK, body)
+		return
+	}
+
+	// 307 would repeat the form submission at the target
+	if req.Method == http.MethodPost {
+		res.Header().Set("Location", url)
+		res.WriteHeader(http.StatusSeeOther)
+		return
+	}
+	redirect(res, url)
 }
 
 func redirect(res http.ResponseWriter, url string) {
 	res.Header().Set("Location", url)
 	res.WriteHeader(http.StatusTemporaryRedirect)
 }
+
+// cookieWriter commits the session of the request before the response is written,
+// and keeps only the last Set-Cookie header of each cookie
+type cookieWriter struct {
+	http.ResponseWriter
+	req     *http.Request
+	written bool
+	failed  bool
+}
+
+func (w *cookieWriter) WriteHeader(status int) {
+	if w.failed {
+		return
+	}
+	if !w.written {
+		w.written = true
+		if err := commitSession(w.ResponseWriter, w.req); err != nil {
+			// the response of the handler would pretend the change was saved
+			w.failed = true
+			w.Header().Del("Location")
+			handleError(w.ResponseWriter, w.req, msgCompleteFailed, fmt.Errorf("can't save user's session: %w", err))
+			return
+		}
+		dedupeCookies(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cookieWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.failed {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// singleCookies saves the session once and sends each cookie once per response
+func singleCookies(handler http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		req = withSessionHolder(req)
+		w := &cookieWriter{ResponseWriter: res, req: req}
+		handler(w, req)
+		if !w.written {
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+}
+
+func dedupeCookies(h http.Header) {
+	cookies := h["Set-Cookie"]
+	if len(cookies) < 2 {
+		return
+	}
+
+	last := make(map[string]int, len(cookies))
+	for i, c := range cookies {
+		last[strings.SplitN(c, "=", 2)[0]] = i
+	}
+	kept := cookies[:0]
+	for i, c := range cookies {
+		if last[strings.SplitN(c, "=", 2)[0]] == i {
+			kept = append(kept, c)
+		}
+	}
+	h["Set-Cookie"] = kept
+}
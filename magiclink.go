@@ -0,0 +1,186 @@
+package login
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+// magicProvider is the provider name of users signed in by the email link
+const magicProvider = "email"
+
+// purposes of signed links, a link of one kind is not accepted as another
+const (
+	magicPurpose = "magic-link:"
+	resetPurpose = "password-reset:"
+)
+
+// Mailer delivers login links, implementations can use SMTP or an email api
+type Mailer interface {
+	SendLink(ctx context.Context, email, link string) error
+}
+
+// MagicLinkConfig describes passwordless login by email link
+type MagicLinkConfig struct {
+	// Secret signs the links, it must be kept private
+	Secret []byte
+	// MaxAge is lifetime of the link, 15 minutes by default
+	MaxAge time.Duration
+	// BaseURL is the public url of the application used in links, e.g. "https://example.com"
+	BaseURL string
+	// SentPage is where the user is redirected after the link is sent, "/" by default
+	SentPage string
+	Mailer   Mailer
+}
+
+// SetMagicLink adds two routes for login by email link: sendURL receives "email" form value
+// and sends the link, verifyURL is opened by the link and signs the user in after a confirmation,
+// as mail scanners open links too. Handler.Login decides whether the user is allowed,
+// like for other providers. Forms are posted, so the router must implement PostRouter
+func SetMagicLink(r Router, sendURL, verifyURL string, cfg MagicLinkConfig, handler Handler) error {
+	if len(cfg.Secret) == 0 {
+		return errors.New("magic link secret is empty")
+	}
+	if cfg.Mailer == nil {
+		return errors.New("magic link mailer is not set")
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = 15 * time.Minute
+	}
+	if cfg.SentPage == "" {
+		cfg.SentPage = "/"
+	}
+
+	send := labeled("magic_link", recoverer(challenged(func(res http.ResponseWriter, req *http.Request) {
+		address, err := mail.ParseAddress(req.PostFormValue("email"))
+		if err != nil {
+			renderError(res, req, http.StatusBadRequest, msgInvalidEmail, nil)
+			return
+		}
+
+		email := normalizeEmail(address.Address)
+		link := strings.TrimSuffix(cfg.BaseURL, "/") + verifyURL + "?token=" + url.QueryEscape(signLink(cfg.Secret, magicPurpose, email))
+		if err := cfg.Mailer.SendLink(req.Context(), email, link); err != nil {
+			reportError(req, err)
+			renderError(res, req, http.StatusServiceUnavailable, msgSendFailed, err)
+			return
+		}
+
+		// the response doesn't depend on the user, addresses can't be probed
+		debugf(req, "%s: link sent to %s", magicProvider, email)
+		respond(res, req, cfg.SentPage, nil)
+	})))
+	if err := addFormRoute(r, sendURL, send); err != nil {
+		return err
+	}
+
+	// mail scanners open links, the token is used only by the button of the page
+	addRoute(r, verifyURL, labeled("callback", recoverer(func(res http.ResponseWriter, req *http.Request) {
+		token := req.URL.Query().Get("token")
+		email, err := verifyLink(cfg.Secret, magicPurpose, cfg.MaxAge, token)
+		if err != nil {
+			emitEvent(req, LoginFailure, magicProvider, goth.User{}, err)
+			handleFailure(res, req, handler, magicProvider, err)
+			return
+		}
+		res.Header().Set("Cache-Control", "no-store")
+		renderPage(res, http.StatusOK, magicLinkPage, func(nonce string) interface{} {
+			return MagicLinkInfo{Email: email, VerifyURL: verifyURL, Token: token, Nonce: nonce, T: messagesFor(req)}
+		})
+	})))
+
+	return addFormRoute(r, verifyURL, labeled("callback", recoverer(func(res http.ResponseWriter, req *http.Request) {
+		token := req.PostFormValue("token")
+		email, err := verifyLink(cfg.Secret, magicPurpose, cfg.MaxAge, token)
+		if err == nil {
+			err = consumeToken(token, cfg.MaxAge)
+		}
+		if err != nil {
+			emitEvent(req, LoginFailure, magicProvider, goth.User{}, err)
+			handleFailure(res, req, handler, magicProvider, err)
+			return
+		}
+
+		user := goth.User{Email: email, Provider: magicProvider}
+		completeLogin(res, req, magicProvider, user, handler)
+	})))
+}
+
+// MagicLinkInfo is passed to the template of the page which confirms login by the email link
+type MagicLinkInfo struct {
+	Email     string
+	VerifyURL string
+	Token     string
+	Nonce     string
+	T         Messages
+}
+
+var magicLinkPage = template.Must(template.New("magic_link").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.T.email_link_title}}</title></head>
+<body>
+<h1>{{.T.email_link_title}}</h1>
+<p>{{printf .T.email_link_text .Email}}</p>
+<form method="POST" action="{{.VerifyURL}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">{{.T.email_link_confirm}}</button>
+</form>
+</body>
+</html>`))
+
+// SetMagicLinkPage defines template of the page which confirms login by the email link
+func SetMagicLinkPage(tmpl *template.Template) {
+	magicLinkPage = tmpl
+}
+
+// signLink creates token in form of base64(time|nonce|email).base64(hmac)
+func signLink(secret []byte, purpose, email string) string {
+	payload := make([]byte, 8+16, 8+16+len(email))
+	binary.BigEndian.PutUint64(payload, uint64(clock().Unix()))
+	randomBytes(payload[8:])
+	payload = append(payload, email...)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(linkMAC(secret, purpose, payload))
+}
+
+// verifyLink checks signature and age of the token, and returns the email
+func verifyLink(secret []byte, purpose string, maxAge time.Duration, token string) (string, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return "", ErrStateMismatch
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(payload) <= 8+16 {
+		return "", ErrStateMismatch
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(mac, linkMAC(secret, purpose, payload)) {
+		return "", ErrStateMismatch
+	}
+
+	created := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if err := checkTimestamps(created, time.Time{}, created.Add(maxAge)); err != nil {
+		return "", ErrStateExpired
+	}
+	return string(payload[8+16:]), nil
+}
+
+func linkMAC(secret []byte, purpose string, payload []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(purpose))
+	h.Write(payload)
+	return h.Sum(nil)
+}
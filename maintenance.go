@@ -0,0 +1,99 @@
+package login
+
+import (
+	"html/template"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/markbates/goth"
+)
+
+// MaintenanceConfig describes logins during maintenance
+type MaintenanceConfig struct {
+	// Allow selects users who can log in during maintenance, e.g. the check of admins
+	Allow func(email string) bool
+	// Page is url where other users are redirected, by default the maintenance page is shown
+	Page string
+}
+
+var maintenance MaintenanceConfig
+var lockdown atomic.Bool
+
+// SetMaintenance defines who can log in while the lockdown is on, the lockdown itself
+// is switched at runtime with Lockdown, so the list of users stays untouched during incidents.
+// The check runs before the gateway, whatever gateway is set
+func SetMaintenance(cfg MaintenanceConfig) {
+	maintenance = cfg
+}
+
+// checkMaintenance denies the login during the lockdown, unless the user is allowed
+func checkMaintenance(res http.ResponseWriter, req *http.Request, user goth.User) bool {
+	if !lockdown.Load() || (maintenance.Allow != nil && maintenance.Allow(user.Email)) {
+		return true
+	}
+
+	emitEvent(req, LoginDenied, user.Provider, user, ErrMaintenance)
+	if maintenance.Page != "" {
+		respond(res, req, maintenance.Page, nil)
+		return false
+	}
+	renderMaintenance(res, req)
+	return false
+}
+
+// Lockdown switches maintenance mode, while it is on only users allowed by SetMaintenance can log in,
+// nobody when it isn't called. Sessions established before are kept
+func Lockdown(on bool) {
+	lockdown.Store(on)
+	if on {
+		logger.Infof("auth: lockdown is on")
+	} else {
+		logger.Infof("auth: lockdown is off")
+	}
+}
+
+// InLockdown reports whether maintenance mode is on
+func InLockdown() bool {
+	return lockdown.Load()
+}
+
+// MaintenanceInfo is passed to the maintenance page template
+type MaintenanceInfo struct {
+	Nonce string
+	T     Messages
+}
+
+var maintenancePage = template.Must(template.New("maintenance").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.T.maintenance_title}}</title></head>
+<body>
+<h1>{{.T.maintenance_title}}</h1>
+<p>{{.T.maintenance_text}}</p>
+</body>
+</html>`))
+
+// SetMaintenancePage defines template of the page shown to users who can't log in during maintenance
+func SetMaintenancePage(tmpl *template.Template) {
+	maintenancePage = tmpl
+}
+
+func renderMaintenance(res http.ResponseWriter, req *http.Request) {
+	message := translate(req, msgMaintenance)
+	if renderPopup(res, req, http.StatusServiceUnavailable, message, map[string]interface{}{
+		"status": "maintenance",
+		"error":  message,
+	}) {
+		return
+	}
+	if jsonMode || wantsJSON(req) {
+		writeJSON(res, http.StatusServiceUnavailable, map[string]interface{}{
+			"status": "maintenance",
+			"error":  message,
+		})
+		return
+	}
+
+	renderPage(res, http.StatusServiceUnavailable, maintenancePage, func(nonce string) interface{} {
+		return MaintenanceInfo{Nonce: nonce, T: messagesFor(req)}
+	})
+}
@@ -0,0 +1,43 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexedwards/scs"
+	"github.com/alexedwards/scs/stores/memstore"
+	"github.com/markbates/goth"
+)
+
+func TestMaintenanceAfterSetGateway(t *testing.T) {
+	previous := store
+	SetSession(scs.NewManager(memstore.New(0)))
+	SetMaintenance(MaintenanceConfig{Allow: func(email string) bool { return email == "admin@example.com" }})
+	Lockdown(true)
+
+	// the gateway replaced after SetMaintenance must not drop the lockdown
+	var passed []string
+	SetGateway(func(res http.ResponseWriter, req *http.Request, user goth.User, handler Handler) {
+		passed = append(passed, user.Email)
+		DefaultGateway(res, req, user, handler)
+	})
+	defer func() {
+		SetSession(previous)
+		SetMaintenance(MaintenanceConfig{})
+		Lockdown(false)
+		SetGateway(DefaultGateway)
+	}()
+
+	handler := acceptHandler("admin@example.com")
+	for _, email := range []string{"user@example.com", "admin@example.com"} {
+		rec := httptest.NewRecorder()
+		completeLogin(rec, httptest.NewRequest("GET", "/callback", nil), "google", goth.User{Email: email, Provider: "google"}, handler)
+		if email == "user@example.com" && rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("login during lockdown responded with %d", rec.Code)
+		}
+	}
+	if len(passed) != 1 || passed[0] != "admin@example.com" {
+		t.Errorf("gateway is called for %v", passed)
+	}
+}
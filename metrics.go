@@ -0,0 +1,298 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are upper bounds (in seconds) of callback latency histogram
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	total  uint64
+}
+
+// rate counts events during the last minute, in one second buckets
+type rate struct {
+	seconds [60]int64
+	counts  [60]int
+}
+
+func (r *rate) add(now int64) {
+	i := now % 60
+	if r.seconds[i] != now {
+		r.seconds[i] = now
+		r.counts[i] = 0
+	}
+	r.counts[i]++
+}
+
+func (r *rate) total(now int64) int {
+	sum := 0
+	for i := range r.seconds {
+		if now-r.seconds[i] < 60 {
+			sum += r.counts[i]
+		}
+	}
+	return sum
+}
+
+// maxSamples limits count of callback samples kept per provider
+const maxSamples = 1000
+
+type sample struct {
+	time     time.Time
+	duration time.Duration
+	success  bool
+}
+
+type metrics struct {
+	sync.Mutex
+	attempts map[[2]string]uint64
+	latency  map[string]*histogram
+	logins   map[string]*rate
+	samples  map[string][]sample
+	denials  map[[2]string]uint64
+	window   time.Duration
+	active   int64
+}
+
+var authMetrics = &metrics{
+	attempts: make(map[[2]string]uint64),
+	latency:  make(map[string]*histogram),
+	logins:   make(map[string]*rate),
+	samples:  make(map[string][]sample),
+	denials:  make(map[[2]string]uint64),
+	window:   15 * time.Minute,
+}
+
+// Statistics contains current authentication activity
+type Statistics struct {
+	// ActiveSessions is the count of established sessions minus logouts since start of the process,
+	// sessions which expired without logout are still counted
+	ActiveSessions  int64
+	LoginsPerMinute map[string]int
+	Providers       map[string]ProviderStats
+}
+
+// ProviderStats contains results of callbacks of the provider over the analytics window
+type ProviderStats struct {
+	Success        int
+	Failure        int
+	MedianDuration time.Duration
+}
+
+// SetAnalyticsWindow defines period used for per-provider statistics, 15 minutes by default
+func SetAnalyticsWindow(window time.Duration) {
+	authMetrics.Lock()
+	authMetrics.window = window
+	authMetrics.Unlock()
+}
+
+// Stats returns current authentication activity
+func Stats() Statistics {
+	return authMetrics.stats()
+}
+
+func (m *metrics) stats() Statistics {
+	m.Lock()
+	defer m.Unlock()
+
+	now := clock().Unix()
+	stats := Statistics{
+		ActiveSessions:  m.active,
+		LoginsPerMinute: make(map[string]int, len(m.logins)),
+	}
+	for provider, r := range m.logins {
+		stats.LoginsPerMinute[provider] = r.total(now)
+	}
+
+	stats.Providers = make(map[string]ProviderStats, len(m.samples))
+	for provider := range m.samples {
+		stats.Providers[provider] = m.providerStats(provider)
+	}
+	return stats
+}
+
+func (m *metrics) providerStats(provider string) ProviderStats {
+	m.trim(provider, clock())
+
+	stats := ProviderStats{}
+	durations := make([]time.Duration, 0, len(m.samples[provider]))
+	for _, s := range m.samples[provider] {
+		if s.success {
+			stats.Success++
+		} else {
+			stats.Failure++
+		}
+		durations = append(durations, s.duration)
+	}
+
+	if len(durations) > 0 {
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		stats.MedianDuration = durations[len(durations)/2]
+	}
+	return stats
+}
+
+// trim removes samples outside of the analytics window
+func (m *metrics) trim(provider string, now time.Time) {
+	samples := m.samples[provider]
+	i := 0
+	for i < len(samples) && now.Sub(samples[i].time) > m.window {
+		i++
+	}
+	if len(samples)-i > maxSamples {
+		i = len(samples) - maxSamples
+	}
+	m.samples[provider] = samples[i:]
+}
+
+func (m *metrics) observeLogout() {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.active > 0 {
+		m.active--
+	}
+}
+
+// observeSession counts the session established for the user, after the handler accepted the login
+func (m *metrics) observeSession(provider string) {
+	m.Lock()
+	defer m.Unlock()
+
+	r, ok := m.logins[provider]
+	if !ok {
+		r = &rate{}
+		m.logins[provider] = r
+	}
+	r.add(clock().Unix())
+	m.active++
+}
+
+// observeDenial counts requests rejected by the guard, reason is "unauthenticated" or "forbidden"
+func (m *metrics) observeDenial(guard, reason string) {
+	m.Lock()
+	m.denials[[2]string{guard, reason}]++
+	m.Unlock()
+}
+
+// observeAttempt counts the login which was completed without the callback
+func (m *metrics) observeAttempt(provider, result string) {
+	m.Lock()
+	m.attempts[[2]string{provider, result}]++
+	m.Unlock()
+}
+
+// loginResult is "success" when the session was established, and "denied"
+// when the authenticated user was refused by hooks, the handler or other checks
+func loginResult(accepted bool) string {
+	if accepted {
+		return "success"
+	}
+	return "denied"
+}
+
+// observeLogin counts the callback, result is "success", "failure" or "denied".
+// Samples describe the provider, so logins denied by the application are its successes
+func (m *metrics) observeLogin(provider, result string, duration time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.attempts[[2]string{provider, result}]++
+	m.samples[provider] = append(m.samples[provider], sample{time: clock(), duration: duration, success: result != "failure"})
+	m.trim(provider, clock())
+
+	h, ok := m.latency[provider]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latency[provider] = h
+	}
+	seconds := duration.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.total++
+}
+
+// MetricsHandler returns handler which exposes login metrics in Prometheus text format
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		authMetrics.write(res)
+	})
+}
+
+func (m *metrics) write(res http.ResponseWriter) {
+	stats := m.stats()
+
+	m.Lock()
+	defer m.Unlock()
+
+	fmt.Fprintln(res, "# HELP login_active_sessions Sessions established and not logged out.")
+	fmt.Fprintln(res, "# TYPE login_active_sessions gauge")
+	fmt.Fprintf(res, "login_active_sessions %d\n", stats.ActiveSessions)
+
+	fmt.Fprintln(res, "# HELP login_logins_per_minute Successful logins during the last minute.")
+	fmt.Fprintln(res, "# TYPE login_logins_per_minute gauge")
+	rates := make([]string, 0, len(stats.LoginsPerMinute))
+	for p := range stats.LoginsPerMinute {
+		rates = append(rates, p)
+	}
+	sort.Strings(rates)
+	for _, p := range rates {
+		fmt.Fprintf(res, "login_logins_per_minute{provider=%q} %d\n", p, stats.LoginsPerMinute[p])
+	}
+
+	fmt.Fprintln(res, "# HELP login_attempts_total Login attempts by provider and result.")
+	fmt.Fprintln(res, "# TYPE login_attempts_total counter")
+	keys := make([][2]string, 0, len(m.attempts))
+	for k := range m.attempts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0]+keys[i][1] < keys[j][0]+keys[j][1]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(res, "login_attempts_total{provider=%q,result=%q} %d\n", k[0], k[1], m.attempts[k])
+	}
+
+	fmt.Fprintln(res, "# HELP login_guard_denials_total Requests rejected by guards by guard and reason.")
+	fmt.Fprintln(res, "# TYPE login_guard_denials_total counter")
+	denials := make([][2]string, 0, len(m.denials))
+	for k := range m.denials {
+		denials = append(denials, k)
+	}
+	sort.Slice(denials, func(i, j int) bool {
+		return denials[i][0]+denials[i][1] < denials[j][0]+denials[j][1]
+	})
+	for _, k := range denials {
+		fmt.Fprintf(res, "login_guard_denials_total{guard=%q,reason=%q} %d\n", k[0], k[1], m.denials[k])
+	}
+
+	fmt.Fprintln(res, "# HELP login_callback_duration_seconds Duration of OAuth callback processing.")
+	fmt.Fprintln(res, "# TYPE login_callback_duration_seconds histogram")
+	providers := make([]string, 0, len(m.latency))
+	for p := range m.latency {
+		providers = append(providers, p)
+	}
+	sort.Strings(providers)
+	for _, p := range providers {
+		h := m.latency[p]
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(res, "login_callback_duration_seconds_bucket{provider=%q,le=\"%g\"} %d\n", p, bound, h.counts[i])
+		}
+		fmt.Fprintf(res, "login_callback_duration_seconds_bucket{provider=%q,le=\"+Inf\"} %d\n", p, h.total)
+		fmt.Fprintf(res, "login_callback_duration_seconds_sum{provider=%q} %g\n", p, h.sum)
+		fmt.Fprintf(res, "login_callback_duration_seconds_count{provider=%q} %d\n", p, h.total)
+	}
+}
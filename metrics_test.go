@@ -0,0 +1,100 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs"
+	"github.com/alexedwards/scs/stores/memstore"
+	"github.com/markbates/goth"
+)
+
+// acceptHandler admits only the user with the email
+type acceptHandler string
+
+func (h acceptHandler) Login(req *http.Request, res http.ResponseWriter, email string) string {
+	if email != string(h) {
+		return ""
+	}
+	return "/app"
+}
+
+func (acceptHandler) Logout(req *http.Request, res http.ResponseWriter) string { return "/" }
+
+// withMetrics replaces metrics of the package for the test
+func withMetrics(t *testing.T) *metrics {
+	previous, previousStore := authMetrics, store
+	authMetrics = &metrics{
+		attempts: make(map[[2]string]uint64),
+		latency:  make(map[string]*histogram),
+		logins:   make(map[string]*rate),
+		samples:  make(map[string][]sample),
+		denials:  make(map[[2]string]uint64),
+		window:   15 * time.Minute,
+	}
+	SetSession(scs.NewManager(memstore.New(0)))
+	t.Cleanup(func() {
+		authMetrics = previous
+		SetSession(previousStore)
+	})
+	return authMetrics
+}
+
+func TestMetricsActiveSessions(t *testing.T) {
+	m := withMetrics(t)
+	handler := acceptHandler("alice@example.com")
+
+	var logins []string
+	previousHooks := hooks[LoginSuccess]
+	OnLogin(func(e Event) { logins = append(logins, e.Email) })
+	defer func() { hooks[LoginSuccess] = previousHooks }()
+
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		req, accepted := trackLogin(httptest.NewRequest("GET", "/callback", nil))
+		DefaultGateway(httptest.NewRecorder(), req, goth.User{Email: email, Provider: "google"}, handler)
+		if *accepted != (email == "alice@example.com") {
+			t.Errorf("login of %s is tracked as accepted=%t", email, *accepted)
+		}
+	}
+	if len(logins) != 1 || logins[0] != "alice@example.com" {
+		t.Errorf("login events are emitted for %v", logins)
+	}
+
+	// bob is denied by the handler, so that login is not a session
+	stats := m.stats()
+	if stats.ActiveSessions != 1 || stats.LoginsPerMinute["google"] != 1 {
+		t.Errorf("expected one session, got %d and %v per minute", stats.ActiveSessions, stats.LoginsPerMinute)
+	}
+}
+
+func TestMetricsDenials(t *testing.T) {
+	m := withMetrics(t)
+	h := Protect(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {}), func(email string) bool {
+		return email == "alice@example.com"
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/admin", nil)
+	if err := SignIn(rec, req, goth.User{Email: "bob@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	store.Use(h).ServeHTTP(httptest.NewRecorder(), req)
+	store.Use(h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/admin", nil))
+
+	rec = httptest.NewRecorder()
+	m.write(rec)
+	for _, line := range []string{
+		`login_guard_denials_total{guard="protect",reason="forbidden"} 1`,
+		`login_guard_denials_total{guard="protect",reason="unauthenticated"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), line) {
+			t.Errorf("metrics have no %s\n%s", line, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package login
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	appRedirectKey  = "login:app_redirect"
+	appStateKey     = "login:app_state"
+	appPKCEKey      = "login:app_challenge"
+	appCodePurpose  = "app_code"
+	appTokenPurpose = "app_token"
+	appCodeMaxAge   = time.Minute
+)
+
+// AppConfig describes login of companion mobile apps
+type AppConfig struct {
+	// Redirects are targets allowed after login, e.g. "com.example.app:/auth"
+	// or app link "https://example.com/app/auth", they are compared exactly
+	Redirects []string
+	// Secret signs codes and access tokens
+	Secret []byte
+	// TokenMaxAge is lifetime of access tokens, 30 days by default
+	TokenMaxAge time.Duration
+}
+
+var appLogin *AppConfig
+
+// SetAppLogin enables login of mobile apps: with "app_redirect" and "code_challenge" (S256)
+// parameters of the login url, after login the browser is sent to the app with a short-lived code
+// and "app_state" parameter, and the app exchanges the code and "code_verifier" for an access token
+// at exchangeURL. Requests with the token in the Authorization header are authenticated by Middleware
+// at the tenant of the login. Logout and the revoke link of the login notice revoke the token
+func SetAppLogin(r Router, exchangeURL string, cfg AppConfig) error {
+	if len(cfg.Secret) < 32 {
+		return errors.New("secret of app login must be at least 32 bytes")
+	}
+	if len(cfg.Redirects) == 0 {
+		return errors.New("app login has no allowed redirects")
+	}
+	if cfg.TokenMaxAge == 0 {
+		cfg.TokenMaxAge = 30 * 24 * time.Hour
+	}
+	err := addFormRoute(r, exchangeURL, labeled("app_exchange", recoverer(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Cache-Control", "no-store")
+
+		code := req.PostFormValue("code")
+		payload, err := verifyLink(cfg.Secret, appCodePurpose, appCodeMaxAge, code)
+		email, rest := splitCode(payload)
+		challenge, grant := splitCode(rest)
+		if err == nil && (challenge == "" || pkceChallenge(req.PostFormValue("code_verifier")) != challenge) {
+			err = fmt.Errorf("%w: code verifier doesn't match", ErrStateMismatch)
+		}
+		if err == nil {
+			err = consumeToken(code, appCodeMaxAge)
+		}
+		if err != nil {
+			debugf(req, "app code is rejected, %s", err.Error())
+			writeJSON(res, ErrorStatus(err), map[string]interface{}{
+				"status": "error",
+				"error":  translate(req, msgSessionExpired),
+			})
+			return
+		}
+
+		writeJSON(res, http.StatusOK, map[string]interface{}{
+			"status":       "ok",
+			"access_token": signLink(cfg.Secret, appTokenPurpose, email+"\n"+grant),
+			"token_type":   "Bearer",
+			"expires_in":   int(cfg.TokenMaxAge.Seconds()),
+			"user":         map[string]string{"email": email},
+		})
+	})))
+	if err != nil {
+		return err
+	}
+	appLogin = &cfg
+	return nil
+}
+
+// AppEmail returns email of the user whose access token is in the Authorization header, or empty string.
+// Tokens of other tenants and revoked tokens are rejected
+func AppEmail(req *http.Request) string {
+	email, sid, tenant, ok := appToken(req)
+	if !ok {
+		return ""
+	}
+	if tenant != CurrentTenant(req) {
+		debugf(req, "app token of tenant %q is rejected", tenant)
+		return ""
+	}
+	revoked, err := isRevoked(sid)
+	if err != nil {
+		logger.Errorf("%sCan't check revoked sessions, %s", logPrefix(req), err.Error())
+		return ""
+	}
+	if revoked {
+		debugf(req, "app token is revoked")
+		return ""
+	}
+	return email
+}
+
+// appToken returns email, login id and tenant of the access token in the Authorization header
+func appToken(req *http.Request) (string, string, string, bool) {
+	header := req.Header.Get("Authorization")
+	if appLogin == nil || !strings.HasPrefix(header, "Bearer ") {
+		return "", "", "", false
+	}
+	payload, err := verifyLink(appLogin.Secret, appTokenPurpose, appLogin.TokenMaxAge, strings.TrimPrefix(header, "Bearer "))
+	if err != nil {
+		debugf(req, "app token is rejected, %s", err.Error())
+		return "", "", "", false
+	}
+	email, rest := splitCode(payload)
+	sid, tenant := splitCode(rest)
+	if sid == "" {
+		return "", "", "", false
+	}
+	return email, sid, tenant, true
+}
+
+// revokeAppTokens revokes tokens issued by the login of the session, and the token
+// of the Authorization header, so logout signs out the app too
+func revokeAppTokens(req *http.Request) error {
+	if appLogin == nil {
+		return nil
+	}
+	if sid, _ := loadSession(req).GetString(sessionIDKey); sid != "" {
+		if err := revokeSession(sid); err != nil {
+			return err
+		}
+	}
+	if _, sid, _, ok := appToken(req); ok {
+		return revokeSession(sid)
+	}
+	return nil
+}
+
+// saveAppRedirect remembers the app which started the login, targets out of the list are rejected
+func saveAppRedirect(res http.ResponseWriter, req *http.Request) error {
+	target := req.URL.Query().Get("app_redirect")
+	if appLogin == nil || target == "" {
+		return nil
+	}
+
+	allowed := false
+	for _, r := range appLogin.Redirects {
+		if r == target {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s", ErrBadRedirect, target)
+	}
+	// the code is sent through the browser, only the app which knows the verifier can exchange it
+	query := req.URL.Query()
+	if query.Get("code_challenge") == "" {
+		return fmt.Errorf("%w: code_challenge is required", ErrBadRedirect)
+	}
+	if method := query.Get("code_challenge_method"); method != "" && method != "S256" {
+		return fmt.Errorf("%w: code_challenge_method %q is not supported", ErrBadRedirect, method)
+	}
+
+	session := loadSession(req)
+	if err := session.PutString(res, appRedirectKey, target); err != nil {
+		return err
+	}
+	if err := session.PutString(res, appStateKey, query.Get("app_state")); err != nil {
+		return err
+	}
+	return session.PutString(res, appPKCEKey, query.Get("code_challenge"))
+}
+
+// redirectToApp sends the user to the app with the code when the login was started by the app,
+// it returns false for other logins
+func redirectToApp(res http.ResponseWriter, req *http.Request, email string) bool {
+	if appLogin == nil {
+		return false
+	}
+	session := loadSession(req)
+	target, _ := session.GetString(appRedirectKey)
+	if target == "" {
+		return false
+	}
+	state, _ := session.GetString(appStateKey)
+	challenge, _ := session.GetString(appPKCEKey)
+	// tokens are revoked along with the session of the login
+	sid, _ := session.GetString(sessionIDKey)
+	for _, key := range []string{appRedirectKey, appStateKey, appPKCEKey} {
+		if err := session.Remove(res, key); err != nil {
+			reportError(req, err)
+		}
+	}
+
+	grant := strings.Join([]string{challenge, sid, CurrentTenant(req)}, "\n")
+	query := url.Values{"code": {signLink(appLogin.Secret, appCodePurpose, email+"\n"+grant)}}
+	if state != "" {
+		query.Set("state", state)
+	}
+	separator := "?"
+	if strings.Contains(target, "?") {
+		separator = "&"
+	}
+	res.Header().Set("Cache-Control", "no-store")
+	res.Header().Set("Location", target+separator+query.Encode())
+	res.WriteHeader(http.StatusSeeOther)
+	return true
+}
+
+// splitCode returns the first line of the payload and the rest, e.g. email and PKCE challenge of the code
+func splitCode(payload string) (string, string) {
+	parts := strings.SplitN(payload, "\n", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// pkceChallenge returns S256 challenge of the verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,268 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+const (
+	sessionIDKey   = "login:sid"
+	deviceCookie   = "login_device"
+	devicePurpose  = "device:"
+	revokePurpose  = "revoke-session:"
+	deviceMaxAge   = 365 * 24 * time.Hour
+	maxDeviceUsers = 10
+)
+
+// LoginNotice describes a login from a new device
+type LoginNotice struct {
+	Email     string
+	Provider  string
+	IP        string
+	UserAgent string
+	Location  string
+	Time      time.Time
+	// RevokeLink signs out the session of the login
+	RevokeLink string
+}
+
+// NoticeMailer delivers login notices, a Mailer of email links can implement it as well
+type NoticeMailer interface {
+	SendLoginNotice(ctx context.Context, notice LoginNotice) error
+}
+
+// LoginNoticeConfig describes notices about logins from new devices
+type LoginNoticeConfig struct {
+	Mailer NoticeMailer
+	// Secret signs revoke links and the device cookie, it must be kept private
+	Secret []byte
+	// BaseURL is the public url of the application used in links, e.g. "https://example.com"
+	BaseURL string
+	// Lifetime of sessions set at scs.Manager, revoke links are valid for it, 7 days by default
+	Lifetime time.Duration
+	// Location returns coarse location of the request, e.g. country by IP,
+	// a login from a new location is notified like one from a new device. Nil checks devices only
+	Location func(req *http.Request) string
+	// RevokedPage is where the user is redirected after the session is revoked, "/" by default
+	RevokedPage string
+}
+
+var loginNotice *LoginNoticeConfig
+var revokeRoute string
+
+// SetLoginNotice sends a notice to users who log in from a new device or location,
+// with an "it wasn't me" link to revokeURL, which signs out the session of that login.
+// Devices are recognized by a signed cookie, revoked sessions are kept in the store of
+// used states, so SetMultiInstance shares them between instances. The link opens a page,
+// which revokes the session with the POST form, so the router must implement PostRouter
+func SetLoginNotice(r Router, revokeURL string, cfg LoginNoticeConfig) error {
+	if len(cfg.Secret) == 0 {
+		return errors.New("login notice secret is empty")
+	}
+	if cfg.Mailer == nil {
+		return errors.New("login notice mailer is not set")
+	}
+	if cfg.Lifetime <= 0 {
+		cfg.Lifetime = 7 * 24 * time.Hour
+	}
+	if cfg.RevokedPage == "" {
+		cfg.RevokedPage = "/"
+	}
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+
+	err := addFormRoute(r, revokeURL, labeled("revoke", recoverer(func(res http.ResponseWriter, req *http.Request) {
+		if err := checkCSRF(req); err != nil {
+			handleError(res, req, msgCompleteFailed, err)
+			return
+		}
+		payload, err := verifyLink(cfg.Secret, revokePurpose, cfg.Lifetime, req.PostFormValue("token"))
+		if err != nil {
+			handleError(res, req, msgSessionExpired, err)
+			return
+		}
+		email, sid := splitCode(payload)
+
+		if err := revokeSession(sid); err != nil {
+			handleError(res, req, msgCompleteFailed, fmt.Errorf("can't revoke session: %w", err))
+			return
+		}
+		logger.Infof("%sauth: session of %s is revoked by the login notice", logPrefix(req), email)
+		respond(res, req, cfg.RevokedPage, nil)
+	})))
+	if err != nil {
+		return err
+	}
+
+	// mail scanners open links, the session is revoked only by the button of the page
+	addRoute(r, revokeURL, labeled("revoke", recoverer(func(res http.ResponseWriter, req *http.Request) {
+		token := req.URL.Query().Get("token")
+		payload, err := verifyLink(cfg.Secret, revokePurpose, cfg.Lifetime, token)
+		if err != nil {
+			handleError(res, req, msgSessionExpired, err)
+			return
+		}
+		email, _ := splitCode(payload)
+
+		csrf := CSRFToken(res, req)
+		res.Header().Set("Cache-Control", "no-store")
+		renderPage(res, http.StatusOK, revokePage, func(nonce string) interface{} {
+			return RevokeInfo{Email: email, RevokeURL: revokeURL, Token: token, CSRFToken: csrf, Nonce: nonce, T: messagesFor(req)}
+		})
+	})))
+	revokeRoute = revokeURL
+	loginNotice = &cfg
+	return nil
+}
+
+// RevokeInfo is passed to the template of the page which confirms revoking of the session
+type RevokeInfo struct {
+	Email     string
+	RevokeURL string
+	Token     string
+	CSRFToken string
+	Nonce     string
+	T         Messages
+}
+
+var revokePage = template.Must(template.New("revoke").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.T.revoke_title}}</title></head>
+<body>
+<h1>{{.T.revoke_title}}</h1>
+<p>{{printf .T.revoke_text .Email}}</p>
+<form method="post" action="{{.RevokeURL}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<button type="submit">{{.T.revoke_confirm}}</button>
+</form>
+</body>
+</html>`))
+
+// SetRevokePage defines template of the page which confirms revoking of the session
+func SetRevokePage(tmpl *template.Template) {
+	revokePage = tmpl
+}
+
+// notifyLogin starts a new session id and sends the notice when the device doesn't know the user,
+// app tokens of the login are revoked by the session id too
+func notifyLogin(res http.ResponseWriter, req *http.Request, user goth.User) error {
+	session := loadSession(req)
+	if loginNotice == nil && appLogin == nil {
+		return session.Remove(res, sessionIDKey)
+	}
+
+	sid := newNonce()
+	if err := session.PutString(res, sessionIDKey, sid); err != nil {
+		return err
+	}
+	if loginNotice == nil {
+		return nil
+	}
+
+	location := ""
+	if loginNotice.Location != nil {
+		location = loginNotice.Location(req)
+	}
+	entry := normalizeEmail(user.Email) + "\t" + location
+	known := knownDevice(req)
+	for _, e := range known {
+		if e == entry {
+			return nil
+		}
+	}
+	saveDevice(res, req, append(known, entry))
+
+	token := signLink(loginNotice.Secret, revokePurpose, user.Email+"\n"+sid)
+	notice := LoginNotice{
+		Email:      user.Email,
+		Provider:   user.Provider,
+		IP:         clientIP(req),
+		UserAgent:  req.UserAgent(),
+		Location:   location,
+		Time:       clock(),
+		RevokeLink: loginNotice.BaseURL + revokeRoute + "?token=" + url.QueryEscape(token),
+	}
+	// the login doesn't wait for the mail
+	mailer, prefix := loginNotice.Mailer, logPrefix(req)
+	go func() {
+		if err := mailer.SendLoginNotice(context.Background(), notice); err != nil {
+			logger.Errorf("%sCan't send login notice to %s, %s", prefix, notice.Email, err.Error())
+		}
+	}()
+	return nil
+}
+
+// sessionRevoked checks whether the session was revoked by the link of the login notice
+func sessionRevoked(req *http.Request) bool {
+	if loginNotice == nil {
+		return false
+	}
+	sid, _ := loadSession(req).GetString(sessionIDKey)
+	if sid == "" {
+		return false
+	}
+	revoked, err := isRevoked(sid)
+	if err != nil {
+		logger.Errorf("%sCan't check revoked sessions, %s", logPrefix(req), err.Error())
+		return false
+	}
+	return revoked
+}
+
+// revokeSession signs out the session and app tokens of the login, the mark is kept
+// while any of them can be valid
+func revokeSession(sid string) error {
+	var lifetime time.Duration
+	if loginNotice != nil {
+		lifetime = loginNotice.Lifetime
+	}
+	if appLogin != nil && appLogin.TokenMaxAge > lifetime {
+		lifetime = appLogin.TokenMaxAge
+	}
+	return usedStates.Save(revokedKey(sid), []byte{1}, clock().Add(lifetime+clockSkew))
+}
+
+func isRevoked(sid string) (bool, error) {
+	_, found, err := usedStates.Find(revokedKey(sid))
+	return found, err
+}
+
+func revokedKey(sid string) string {
+	return "login-revoked:" + sid
+}
+
+// knownDevice returns users and locations which logged in with the browser
+func knownDevice(req *http.Request) []string {
+	c, err := req.Cookie(deviceCookie)
+	if err != nil {
+		return nil
+	}
+	payload, err := verifyLink(loginNotice.Secret, devicePurpose, deviceMaxAge, c.Value)
+	if err != nil || payload == "" {
+		return nil
+	}
+	return strings.Split(payload, "\n")
+}
+
+func saveDevice(res http.ResponseWriter, req *http.Request, entries []string) {
+	if len(entries) > maxDeviceUsers {
+		entries = entries[len(entries)-maxDeviceUsers:]
+	}
+	http.SetCookie(res, &http.Cookie{
+		Name:     deviceCookie,
+		Value:    signLink(loginNotice.Secret, devicePurpose, strings.Join(entries, "\n")),
+		Path:     "/",
+		MaxAge:   int(deviceMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(absoluteURL(req, "/"), "https:"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,46 @@
+package login
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type noticeMailer struct{}
+
+func (noticeMailer) SendLoginNotice(ctx context.Context, notice LoginNotice) error { return nil }
+
+func TestRevokeByForm(t *testing.T) {
+	secret := []byte("notice-secret")
+	r := formRouter{http.NewServeMux()}
+	if err := SetLoginNotice(r, "/revoke", LoginNoticeConfig{Mailer: noticeMailer{}, Secret: secret}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { loginNotice, revokeRoute = nil, "" }()
+
+	token := signLink(secret, revokePurpose, "user@example.com\nsid-1")
+	jar := cookieJar{}
+	page := httptest.NewRecorder()
+	r.ServeHTTP(page, httptest.NewRequest("GET", "/revoke?confirm=1&token="+url.QueryEscape(token), nil))
+	if revoked, _ := isRevoked("sid-1"); revoked || page.Code != http.StatusOK {
+		t.Fatalf("link revokes the session, page responded with %d", page.Code)
+	}
+	jar.request(httptest.NewRequest("GET", "/", nil), page)
+
+	post := func(form url.Values) {
+		req := jar.request(httptest.NewRequest("POST", "/revoke", strings.NewReader(form.Encode())), nil)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	post(url.Values{"token": {token}})
+	if revoked, _ := isRevoked("sid-1"); revoked {
+		t.Fatal("session is revoked without the csrf token")
+	}
+	post(url.Values{"token": {token}, "csrf_token": {jar[csrfCookie].Value}})
+	if revoked, _ := isRevoked("sid-1"); !revoked {
+		t.Error("session is not revoked by the form")
+	}
+}
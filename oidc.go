@@ -0,0 +1,290 @@
+package login
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/markbates/goth"
+	"golang.org/x/oauth2"
+)
+
+// OIDCProvider is a generic OpenID Connect provider, e.g. Keycloak or Dex. Endpoints and signing
+// keys are read from the discovery document of the issuer, id tokens are verified by VerifyIDToken
+type OIDCProvider struct {
+	name     string
+	issuer   string
+	clientID string
+	secret   string
+	callback string
+	scopes   []string
+	keys     *KeyCache
+
+	// HTTPClient is used for the token exchange and userinfo, SetHTTPClient sets it
+	HTTPClient *http.Client
+}
+
+// NewOIDCProvider creates provider of the issuer, e.g. "https://keycloak.example.com/realms/main",
+// "openid" and "email" scopes are always requested
+func NewOIDCProvider(issuer, clientKey, secret, callbackURL string, scopes ...string) *OIDCProvider {
+	issuer = strings.TrimSuffix(issuer, "/")
+	all := []string{"openid", "email"}
+	for _, s := range scopes {
+		if s != "openid" && s != "email" {
+			all = append(all, s)
+		}
+	}
+	return &OIDCProvider{
+		name:     "oidc",
+		issuer:   issuer,
+		clientID: clientKey,
+		secret:   secret,
+		callback: callbackURL,
+		scopes:   all,
+		keys:     NewKeyCache(issuer+"/.well-known/openid-configuration", time.Hour),
+	}
+}
+
+// Name returns name of the provider, "oidc" by default
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+// SetName renames the provider, e.g. to serve several issuers
+func (p *OIDCProvider) SetName(name string) {
+	p.name = name
+}
+
+// Debug is a no-op, use SetDebug of the package
+func (p *OIDCProvider) Debug(bool) {}
+
+// BeginAuth creates the session with the auth url of the issuer, nonce of the session
+// is checked in the id token
+func (p *OIDCProvider) BeginAuth(state string) (goth.Session, error) {
+	cfg, err := p.config()
+	if err != nil {
+		return nil, err
+	}
+	nonce := oidcNonce(state)
+	return &OIDCSession{
+		AuthURL: cfg.AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", nonce)),
+		Nonce:   nonce,
+	}, nil
+}
+
+// oidcNonce derives the nonce from the signed state, so the session recreated from the state
+// at the callback expects the nonce sent to the issuer. Without signed state the nonce is random
+func oidcNonce(state string) string {
+	if stateSecret == nil || stateNonce(state) == "" {
+		return newNonce()
+	}
+	h := hmac.New(sha256.New, stateSecret)
+	h.Write([]byte("oidc-nonce:" + state))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// UnmarshalSession restores the session saved by Marshal
+func (p *OIDCProvider) UnmarshalSession(data string) (goth.Session, error) {
+	s := &OIDCSession{}
+	err := json.Unmarshal([]byte(data), s)
+	return s, err
+}
+
+// FetchUser returns the user of the verified id token, claims missing in the token
+// are read from the userinfo endpoint. Users with unverified email are rejected
+func (p *OIDCProvider) FetchUser(session goth.Session) (goth.User, error) {
+	s := session.(*OIDCSession)
+	user := goth.User{
+		Provider:     p.name,
+		AccessToken:  s.AccessToken,
+		RefreshToken: s.RefreshToken,
+		ExpiresAt:    s.ExpiresAt,
+	}
+	if s.IDToken == "" {
+		return user, fmt.Errorf("%w: %s didn't return id token", ErrInvalidToken, p.name)
+	}
+
+	claims, err := VerifyIDToken(p.keys, s.IDToken, p.clientID)
+	if err != nil {
+		return user, err
+	}
+	if claims.Email == "" {
+		if err := p.userinfo(s.AccessToken, &claims); err != nil {
+			return user, err
+		}
+	}
+	if verified, ok := claims.Raw["email_verified"].(bool); ok && !verified {
+		return user, fmt.Errorf("%w: email %s is not verified", ErrInvalidToken, claims.Email)
+	}
+
+	user.UserID = claims.Subject
+	user.Email = claims.Email
+	user.Name = claims.Name
+	user.AvatarURL = claims.Picture
+	user.RawData = claims.Raw
+	user.RawData["id_token"] = s.IDToken
+	return user, nil
+}
+
+// RefreshTokenAvailable reports that tokens can be refreshed
+func (p *OIDCProvider) RefreshTokenAvailable() bool {
+	return true
+}
+
+// RefreshToken exchanges the refresh token for a new access token
+func (p *OIDCProvider) RefreshToken(refreshToken string) (*oauth2.Token, error) {
+	return p.RefreshTokenContext(context.Background(), refreshToken)
+}
+
+// RefreshTokenContext exchanges the refresh token, the request is cancelled with the context
+func (p *OIDCProvider) RefreshTokenContext(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
+	cfg, err := p.config()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.TokenSource(p.context(ctx), &oauth2.Token{RefreshToken: refreshToken}).Token()
+}
+
+func (p *OIDCProvider) config() (*oauth2.Config, error) {
+	discovery, err := p.keys.Discovery()
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("discovery document is of issuer %q instead of %q", discovery.Issuer, p.issuer)
+	}
+	return &oauth2.Config{
+		ClientID:     p.clientID,
+		ClientSecret: p.secret,
+		RedirectURL:  p.callback,
+		Scopes:       p.scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  discovery.AuthorizationEndpoint,
+			TokenURL: discovery.TokenEndpoint,
+		},
+	}, nil
+}
+
+func (p *OIDCProvider) context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, goth.HTTPClientWithFallBack(p.HTTPClient))
+}
+
+// userinfo fills email, name and picture from the userinfo endpoint
+func (p *OIDCProvider) userinfo(accessToken string, claims *IDClaims) error {
+	discovery, err := p.keys.Discovery()
+	if err != nil {
+		return err
+	}
+	if discovery.UserinfoEndpoint == "" {
+		return fmt.Errorf("%w: token has no email and issuer has no userinfo endpoint", ErrInvalidToken)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, discovery.UserinfoEndpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	res, err := goth.HTTPClientWithFallBack(p.HTTPClient).Do(req)
+	if err != nil {
+		return fmt.Errorf("can't fetch userinfo: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("can't fetch userinfo: unexpected status %d", res.StatusCode)
+	}
+
+	var info map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return fmt.Errorf("can't fetch userinfo: %w", err)
+	}
+	// claims of another subject must not be mixed into the token
+	if sub, _ := info["sub"].(string); sub != claims.Subject {
+		return fmt.Errorf("%w: userinfo is of another subject", ErrInvalidToken)
+	}
+	for key, value := range info {
+		if _, ok := claims.Raw[key]; !ok {
+			claims.Raw[key] = value
+		}
+	}
+	claims.Email, _ = info["email"].(string)
+	if claims.Name == "" {
+		claims.Name, _ = info["name"].(string)
+	}
+	if claims.Picture == "" {
+		claims.Picture, _ = info["picture"].(string)
+	}
+	if claims.Email == "" {
+		return fmt.Errorf("%w: issuer returned no email", ErrInvalidToken)
+	}
+	return nil
+}
+
+// OIDCSession keeps state of the authentication between the login and the callback
+type OIDCSession struct {
+	AuthURL      string
+	Nonce        string
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+	IDToken      string
+	// Scope is granted by the token response, empty when the provider doesn't report it
+	Scope string
+}
+
+// GetAuthURL returns url of the authorization endpoint
+func (s *OIDCSession) GetAuthURL() (string, error) {
+	if s.AuthURL == "" {
+		return "", errors.New(goth.NoAuthUrlErrorMessage)
+	}
+	return s.AuthURL, nil
+}
+
+// Marshal saves the session as JSON
+func (s *OIDCSession) Marshal() string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// Authorize exchanges the code for tokens and checks nonce of the id token
+func (s *OIDCSession) Authorize(provider goth.Provider, params goth.Params) (string, error) {
+	return s.AuthorizeContext(context.Background(), provider, params)
+}
+
+// AuthorizeContext is Authorize, the token request is cancelled with the context
+func (s *OIDCSession) AuthorizeContext(ctx context.Context, provider goth.Provider, params goth.Params) (string, error) {
+	p := provider.(*OIDCProvider)
+	cfg, err := p.config()
+	if err != nil {
+		return "", err
+	}
+	token, err := cfg.Exchange(p.context(ctx), params.Get("code"))
+	if err != nil {
+		return "", err
+	}
+	if !token.Valid() {
+		return "", errors.New("invalid token received from provider")
+	}
+
+	idToken, _ := token.Extra("id_token").(string)
+	claims, err := VerifyIDToken(p.keys, idToken, p.clientID)
+	if err != nil {
+		return "", err
+	}
+	if claims.Nonce != s.Nonce {
+		return "", fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+
+	s.AccessToken = token.AccessToken
+	s.RefreshToken = token.RefreshToken
+	s.ExpiresAt = token.Expiry
+	s.IDToken = idToken
+	s.Scope, _ = token.Extra("scope").(string)
+	return token.AccessToken, nil
+}
@@ -0,0 +1,72 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+// googleKeys fetches the keys on first use, so apps without id tokens don't fetch anything
+var googleKeys = NewKeyCache(GoogleDiscoveryURL, time.Hour)
+
+// SetGoogleKeys replaces cache of Google signing keys used to verify id tokens,
+// e.g. with a cache of a test server
+func SetGoogleKeys(keys *KeyCache) {
+	googleKeys = keys
+}
+
+// SetOneTap adds the route which receives credential of Google One Tap and the Sign In button,
+// use its url as "data-login_uri" of the button. The id token is verified against clientID and
+// the user goes through the same hooks and Handler.Login as after the callback.
+// The route accepts only POST, so the router must implement PostRouter
+func SetOneTap(r Router, url, clientID string, handler Handler) error {
+	return addFormRoute(r, url, labeled("one_tap", recoverer(func(res http.ResponseWriter, req *http.Request) {
+		user, err := oneTapUser(req, clientID)
+		if err != nil {
+			emitEvent(req, LoginFailure, "google", goth.User{}, err)
+			handleFailure(res, req, handler, "google", err)
+			return
+		}
+
+		completeLogin(res, req, "google", user, handler)
+	})))
+}
+
+// oneTapUser checks double submit cookie of Google and the posted id token
+func oneTapUser(req *http.Request, clientID string) (goth.User, error) {
+	cookie, err := req.Cookie("g_csrf_token")
+	if err != nil || cookie.Value == "" || cookie.Value != req.PostFormValue("g_csrf_token") {
+		return goth.User{}, fmt.Errorf("%w: csrf token of one tap is missing", ErrStateMismatch)
+	}
+
+	credential := req.PostFormValue("credential")
+	claims, err := VerifyIDToken(googleKeys, credential, clientID)
+	if err != nil {
+		return goth.User{}, err
+	}
+	if !claims.EmailVerified {
+		return goth.User{}, fmt.Errorf("%w: email %s is not verified", ErrInvalidToken, claims.Email)
+	}
+
+	return goth.User{
+		Provider:  "google",
+		UserID:    claims.Subject,
+		Email:     claims.Email,
+		Name:      claims.Name,
+		AvatarURL: claims.Picture,
+		ExpiresAt: time.Unix(claims.Expires, 0),
+		RawData:   oneTapData(claims, credential),
+	}, nil
+}
+
+// oneTapData keeps all claims of the token in RawData, as the provider keeps its userinfo
+func oneTapData(claims IDClaims, credential string) map[string]interface{} {
+	data := make(map[string]interface{}, len(claims.Raw)+1)
+	for k, v := range claims.Raw {
+		data[k] = v
+	}
+	data["id_token"] = credential
+	return data
+}
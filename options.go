@@ -0,0 +1,68 @@
+package login
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/alexedwards/scs"
+)
+
+// Option changes configuration of the package, see Configure
+type Option func()
+
+// Configure applies options, it is an alternative to calling the Set* functions one by one
+func Configure(opts ...Option) {
+	for _, opt := range opts {
+		opt()
+	}
+}
+
+// WithSession defines session store
+func WithSession(session *scs.Manager) Option {
+	return func() { SetSession(session) }
+}
+
+// WithLogger defines logger
+func WithLogger(l Logger) Option {
+	return func() { SetLogger(l) }
+}
+
+// WithErrorPage defines template of the error page
+func WithErrorPage(tmpl *template.Template) Option {
+	return func() { SetErrorPage(tmpl) }
+}
+
+// WithErrorHandler defines handler of authentication failures
+func WithErrorHandler(handler func(res http.ResponseWriter, req *http.Request, err error)) Option {
+	return func() { SetErrorHandler(handler) }
+}
+
+// WithErrorReporter defines reporter of unexpected failures
+func WithErrorReporter(r ErrorReporter) Option {
+	return func() { SetErrorReporter(r) }
+}
+
+// WithTracer defines tracer
+func WithTracer(t Tracer) Option {
+	return func() { SetTracer(t) }
+}
+
+// WithEventHandler defines receiver of all authentication events
+func WithEventHandler(handler func(Event)) Option {
+	return func() { SetEventHandler(handler) }
+}
+
+// WithDevBypass signs in all logins as the user with the email, see SetDevBypass.
+// The option is ignored with an error in the log when the binary is not built with "-tags dev"
+func WithDevBypass(email string) Option {
+	return func() {
+		if err := SetDevBypass(email); err != nil {
+			logger.Errorf("Can't enable dev bypass, %s", err.Error())
+		}
+	}
+}
+
+// WithHook registers hook for the event type
+func WithHook(t EventType, hook func(Event)) Option {
+	return func() { hooks[t] = append(hooks[t], hook) }
+}
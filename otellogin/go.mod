@@ -0,0 +1,34 @@
+module github.com/mkozhukh/login/otellogin
+
+go 1.27.1
+
+require (
+	github.com/mkozhukh/login v0.0.0
+	go.opentelemetry.io/otel v1.46.0
+	go.opentelemetry.io/otel/sdk v1.46.0
+	go.opentelemetry.io/otel/trace v1.46.0
+)
+
+require (
+	cloud.google.com/go v0.30.0 // indirect
+	github.com/BurntSushi/toml v1.6.0 // indirect
+	github.com/alexedwards/scs v1.4.0 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/go-logr/logr v1.4.4 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/golang/protobuf v1.2.0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/markbates/goth v1.49.0 // indirect
+	github.com/patrickmn/go-cache v2.1.0+incompatible // indirect
+	go.opentelemetry.io/auto/sdk v1.2.1 // indirect
+	go.opentelemetry.io/otel/metric v1.46.0 // indirect
+	golang.org/x/crypto v0.0.0-20190313024323-a1f597ede03a // indirect
+	golang.org/x/net v0.0.0-20180724234803-3673e40ba225 // indirect
+	golang.org/x/oauth2 v0.0.0-20180620175406-ef147856a6dd // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	google.golang.org/appengine v1.2.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/mkozhukh/login => ../
@@ -0,0 +1,51 @@
+// Package otellogin reports steps of the login package as OpenTelemetry spans.
+// It is a separate module, so the package itself doesn't depend on OpenTelemetry
+//
+//	login.SetTracer(otellogin.NewTracer(nil))
+package otellogin
+
+import (
+	"context"
+
+	"github.com/mkozhukh/login"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentation = "github.com/mkozhukh/login"
+
+type tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer returns tracer of the provider, nil uses the global provider of otel.
+// Spans have "auth.provider", "auth.tenant" and "auth.outcome" attributes, errors set the error status
+func NewTracer(provider trace.TracerProvider) login.Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return tracer{tracer: provider.Tracer(instrumentation)}
+}
+
+func (t tracer) Start(ctx context.Context, name string, provider string) (context.Context, login.Span) {
+	ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(attribute.String("auth.provider", provider)))
+	return ctx, otelSpan{span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) SetAttribute(key, value string) {
+	s.span.SetAttributes(attribute.String(key, value))
+}
+
+func (s otelSpan) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
@@ -0,0 +1,41 @@
+package otellogin
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mkozhukh/login"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var _ login.AttributeSpan = otelSpan{}
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tr := NewTracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	_, span := tr.Start(context.Background(), "login.callback", "acme:google")
+	span.(login.AttributeSpan).SetAttribute("auth.tenant", "acme")
+	span.(login.AttributeSpan).SetAttribute("auth.outcome", "failure")
+	span.End(errors.New("token exchange failed"))
+
+	ended := recorder.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("%d spans are recorded", len(ended))
+	}
+	s := ended[0]
+	attrs := map[attribute.Key]string{}
+	for _, a := range s.Attributes() {
+		attrs[a.Key] = a.Value.AsString()
+	}
+	if s.Name() != "login.callback" || attrs["auth.provider"] != "acme:google" || attrs["auth.tenant"] != "acme" || attrs["auth.outcome"] != "failure" {
+		t.Errorf("span %s has attributes %v", s.Name(), attrs)
+	}
+	if s.Status().Code != codes.Error || len(s.Events()) != 1 {
+		t.Errorf("span has status %v and %d events", s.Status(), len(s.Events()))
+	}
+}
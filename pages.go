@@ -0,0 +1,261 @@
+package login
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrorInfo is passed to the error page template
+type ErrorInfo struct {
+	Status   int
+	Message  string
+	RetryURL string
+	Nonce    string
+	T        Messages
+}
+
+// NoncePlaceholder is replaced with a per-response nonce in the CSP policy
+const NoncePlaceholder = "{nonce}"
+
+var contentSecurityPolicy = "default-src 'none'; style-src 'nonce-{nonce}'; script-src 'nonce-{nonce}'; img-src 'self'; form-action 'self'; frame-ancestors 'none'; base-uri 'none'"
+
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.T.auth_failed}}</title></head>
+<body>
+<h1>{{.T.auth_failed}}</h1>
+<p>{{.Message}}</p>
+{{if .RetryURL}}<p><a href="{{.RetryURL}}">{{.T.try_again}}</a></p>{{end}}
+</body>
+</html>`))
+
+// DeniedInfo is passed to the denied page template
+type DeniedInfo struct {
+	Email     string
+	SwitchURL string
+	Nonce     string
+	T         Messages
+}
+
+// loginRoute is path of the login route, set by SetRoutes
+var loginRoute = ""
+
+var deniedPage = template.Must(template.New("denied").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.T.denied_title}}</title></head>
+<body>
+<h1>{{.T.denied_title}}</h1>
+<p>{{printf .T.denied_text .Email}}</p>
+{{if .SwitchURL}}<p><a href="{{.SwitchURL}}">{{.T.switch_account}}</a></p>{{end}}
+</body>
+</html>`))
+
+// SetDeniedPage defines template used when Handler.Login denies the user
+func SetDeniedPage(tmpl *template.Template) {
+	deniedPage = tmpl
+}
+
+func renderDenied(res http.ResponseWriter, req *http.Request, email string) {
+	if renderPopup(res, req, http.StatusForbidden, fmt.Sprintf(translate(req, "denied_text"), email), map[string]interface{}{
+		"status": "denied",
+		"email":  email,
+	}) {
+		return
+	}
+	if jsonMode || wantsJSON(req) {
+		writeJSON(res, http.StatusForbidden, map[string]interface{}{
+			"status": "denied",
+			"email":  email,
+		})
+		return
+	}
+
+	renderPage(res, http.StatusForbidden, deniedPage, func(nonce string) interface{} {
+		return DeniedInfo{Email: email, SwitchURL: loginRoute, Nonce: nonce, T: messagesFor(req)}
+	})
+}
+
+// LoginInfo is passed to the login page template
+type LoginInfo struct {
+	Providers []ProviderLink
+	Nonce     string
+	T         Messages
+}
+
+// ProviderLink is a button of the login page
+type ProviderLink struct {
+	Name  string `json:"name"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+var providerTitles = map[string]string{
+	"google":          "Google",
+	"gplus":           "Google",
+	"github":          "GitHub",
+	"gitlab":          "GitLab",
+	"microsoftonline": "Microsoft",
+	"azureadv2":       "Microsoft",
+	"oidc":            "SSO",
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.T.sign_in}}</title>
+<style nonce="{{.Nonce}}">
+body { font-family: sans-serif; display: flex; justify-content: center; margin-top: 15vh; }
+.providers a { display: block; width: 260px; margin: 8px 0; padding: 10px; border-radius: 4px; text-align: center; text-decoration: none; color: #fff; background: #555; }
+.providers .google, .providers .gplus { background: #4285f4; }
+.providers .github { background: #24292e; }
+.providers .gitlab { background: #fc6d26; }
+.providers .microsoftonline, .providers .azureadv2 { background: #2f2f2f; }
+</style>
+</head>
+<body>
+<div class="providers">
+<h1>{{.T.sign_in}}</h1>
+{{range .Providers}}<a class="{{.Name}}" href="{{.URL}}">{{printf $.T.sign_in_with .Title}}</a>
+{{end}}</div>
+</body>
+</html>`))
+
+// SetLoginPage defines template of the page shown by the login route when provider is not selected
+func SetLoginPage(tmpl *template.Template) {
+	loginPage = tmpl
+}
+
+// renderLogin shows buttons of configured providers, links pass provider name in the "provider" query parameter
+func renderLogin(res http.ResponseWriter, req *http.Request, loginURL string) {
+	links := make([]ProviderLink, 0, len(enabledProviders))
+	hint := ""
+	if h := loginHint(req); h != "" {
+		hint = "&login_hint=" + url.QueryEscape(h)
+	}
+	for _, name := range enabledProviders {
+		title, ok := providerTitles[name]
+		if !ok {
+			title = strings.Title(name)
+		}
+		links = append(links, ProviderLink{
+			Name:  name,
+			Title: title,
+			URL:   loginURL + "?provider=" + url.QueryEscape(name) + hint,
+		})
+	}
+
+	if jsonMode || wantsJSON(req) {
+		writeJSON(res, http.StatusOK, map[string]interface{}{
+			"status":    "select_provider",
+			"providers": links,
+		})
+		return
+	}
+
+	renderPage(res, http.StatusOK, loginPage, func(nonce string) interface{} {
+		return LoginInfo{Providers: links, Nonce: nonce, T: messagesFor(req)}
+	})
+}
+
+// SetErrorPage defines template used to render authentication failures
+func SetErrorPage(tmpl *template.Template) {
+	errorPage = tmpl
+}
+
+// SetContentSecurityPolicy defines CSP header sent with all served pages,
+// use NoncePlaceholder in policy to allow inline styles and scripts marked with {{.Nonce}}
+func SetContentSecurityPolicy(policy string) {
+	contentSecurityPolicy = policy
+}
+
+// renderPage writes html page with security headers
+func renderPage(res http.ResponseWriter, status int, tmpl *template.Template, data func(nonce string) interface{}) {
+	renderPageWithPolicy(res, status, tmpl, contentSecurityPolicy, data)
+}
+
+// renderPageWithPolicy writes html page with its own CSP, for pages with third-party widgets
+func renderPageWithPolicy(res http.ResponseWriter, status int, tmpl *template.Template, policy string, data func(nonce string) interface{}) {
+	nonce := newNonce()
+	if policy != "" {
+		res.Header().Set("Content-Security-Policy", strings.Replace(policy, NoncePlaceholder, nonce, -1))
+	}
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.WriteHeader(status)
+
+	if err := tmpl.Execute(res, data(nonce)); err != nil {
+		logger.Errorf("Can't render %s page, %s", tmpl.Name(), err.Error())
+	}
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		logger.Errorf("Can't generate nonce, %s", err.Error())
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// renderError logs the full error and shows only a safe message to the user,
+// key is the key of the message in the catalog
+func renderError(res http.ResponseWriter, req *http.Request, status int, key string, err error) {
+	renderRetry(res, req, status, key, "", err)
+}
+
+// renderRetry renders the error page with a link to start authentication again
+func renderRetry(res http.ResponseWriter, req *http.Request, status int, key string, retryURL string, err error) {
+	if err != nil {
+		logger.Errorf("%s%s, %s", logPrefix(req), catalog["en"][key], err.Error())
+	}
+	message := translate(req, key)
+
+	body := map[string]interface{}{
+		"status": "error",
+		"error":  message,
+	}
+	if retryURL != "" {
+		body["retry"] = retryURL
+	}
+	if renderPopup(res, req, status, message, body) {
+		return
+	}
+	if jsonMode || wantsJSON(req) {
+		writeJSON(res, status, body)
+		return
+	}
+
+	renderPage(res, status, errorPage, func(nonce string) interface{} {
+		return ErrorInfo{Status: status, Message: message, RetryURL: retryURL, Nonce: nonce, T: messagesFor(req)}
+	})
+}
+
+func writeJSON(res http.ResponseWriter, status int, body interface{}) {
+	res.Header().Set("Content-Type", "application/json; charset=utf-8")
+	res.WriteHeader(status)
+	if err := json.NewEncoder(res).Encode(body); err != nil {
+		logger.Errorf("Can't write json response, %s", err.Error())
+	}
+}
+
+var isAPIRequest = DefaultAPIRequest
+
+// SetAPIRequest defines predicate which detects api calls, they receive JSON responses
+// instead of redirects and pages
+func SetAPIRequest(predicate func(req *http.Request) bool) {
+	isAPIRequest = predicate
+}
+
+// DefaultAPIRequest detects requests which accept JSON or are sent by XMLHttpRequest
+func DefaultAPIRequest(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), "application/json") ||
+		req.Header.Get("X-Requested-With") == "XMLHttpRequest"
+}
+
+func wantsJSON(req *http.Request) bool {
+	return isAPIRequest(req)
+}
@@ -0,0 +1,276 @@
+package login
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markbates/goth"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordProvider is the provider name of users signed in by password
+const passwordProvider = "password"
+
+// PasswordStore keeps password hashes of local users
+type PasswordStore interface {
+	// PasswordHash returns the hash of the user, or nil when the user is unknown
+	PasswordHash(email string) ([]byte, error)
+	SetPasswordHash(email string, hash []byte) error
+}
+
+// PasswordConfig describes login by email and password
+type PasswordConfig struct {
+	Store PasswordStore
+	// FormPage is the page with the login form, it is used for "try again" links
+	FormPage string
+	// MinLength is the minimal length of a new password, 8 by default
+	MinLength int
+
+	// Password reset is enabled when Mailer is set.
+	// Secret signs the reset links, MaxAge is their lifetime, 1 hour by default
+	Secret []byte
+	MaxAge time.Duration
+	// BaseURL is the public url of the application used in links, e.g. "https://example.com"
+	BaseURL string
+	// ResetPage is the page with the new password form, the link adds "token" parameter to it
+	ResetPage string
+	// SentPage is shown after the reset link is requested, DonePage after the password is changed,
+	// both are "/" by default
+	SentPage string
+	DonePage string
+	Mailer   Mailer
+}
+
+// HashPassword returns bcrypt hash of the password, suitable for PasswordStore
+func HashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+// SetPasswordLogin adds the login route for "email" and "password" form values and,
+// when cfg.Mailer is set, the reset route. The reset route sends a link for the "email" value,
+// or sets the "password" value when the form contains "token" from the link.
+// Stored hashes can be bcrypt or argon2id in PHC format. Handler.Login decides whether
+// the user is allowed, like for other providers. Routes accept only POST, so the router
+// must implement PostRouter, and forms must carry CSRFToken in the "csrf_token" field
+func SetPasswordLogin(r Router, loginURL, resetURL string, cfg PasswordConfig, handler Handler) error {
+	if cfg.Store == nil {
+		return errors.New("password store is not set")
+	}
+	if cfg.Mailer != nil {
+		if len(cfg.Secret) == 0 {
+			return errors.New("password reset secret is empty")
+		}
+		if cfg.ResetPage == "" {
+			return errors.New("password reset page is not set")
+		}
+	}
+	if cfg.MinLength <= 0 {
+		cfg.MinLength = 8
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = time.Hour
+	}
+	if cfg.SentPage == "" {
+		cfg.SentPage = "/"
+	}
+	if cfg.DonePage == "" {
+		cfg.DonePage = "/"
+	}
+	if cfg.FormPage != "" {
+		retryPages[passwordProvider] = cfg.FormPage
+	}
+
+	err := addFormRoute(r, loginURL, labeled("password", recoverer(challenged(func(res http.ResponseWriter, req *http.Request) {
+		if err := checkCSRF(req); err != nil {
+			handleError(res, req, msgSessionExpired, err)
+			return
+		}
+
+		email := normalizeEmail(req.PostFormValue("email"))
+		err := checkPassword(cfg.Store, email, req.PostFormValue("password"))
+		if err != nil {
+			emitEvent(req, LoginFailure, passwordProvider, goth.User{Email: email}, err)
+			handleFailure(res, req, handler, passwordProvider, err)
+			return
+		}
+
+		user := goth.User{Email: email, Provider: passwordProvider}
+		completeLogin(res, req, passwordProvider, user, handler)
+	}))))
+	if err != nil || cfg.Mailer == nil {
+		return err
+	}
+
+	return addFormRoute(r, resetURL, labeled("password_reset", recoverer(func(res http.ResponseWriter, req *http.Request) {
+		if err := checkCSRF(req); err != nil {
+			handleError(res, req, msgSessionExpired, err)
+			return
+		}
+		if token := req.PostFormValue("token"); token != "" {
+			resetPassword(res, req, cfg, token)
+			return
+		}
+
+		address, err := mail.ParseAddress(req.PostFormValue("email"))
+		if err != nil {
+			renderError(res, req, http.StatusBadRequest, msgInvalidEmail, nil)
+			return
+		}
+		email := normalizeEmail(address.Address)
+
+		hash, err := cfg.Store.PasswordHash(email)
+		if err != nil {
+			reportError(req, err)
+			renderError(res, req, http.StatusInternalServerError, msgSendFailed, err)
+			return
+		}
+		// the response doesn't depend on the user, addresses can't be probed
+		if hash != nil {
+			link := strings.TrimSuffix(cfg.BaseURL, "/") + cfg.ResetPage + "?token=" + url.QueryEscape(signLink(cfg.Secret, resetPurpose, email))
+			if err := cfg.Mailer.SendLink(req.Context(), email, link); err != nil {
+				reportError(req, err)
+				renderError(res, req, http.StatusServiceUnavailable, msgSendFailed, err)
+				return
+			}
+			debugf(req, "%s: reset link sent to %s", passwordProvider, email)
+		}
+		respond(res, req, cfg.SentPage, nil)
+	})))
+}
+
+// resetPassword sets the new password of the user from the reset link, each link works once
+func resetPassword(res http.ResponseWriter, req *http.Request, cfg PasswordConfig, token string) {
+	password := req.PostFormValue("password")
+	if len(password) < cfg.MinLength {
+		renderError(res, req, http.StatusBadRequest, msgWeakPassword, nil)
+		return
+	}
+
+	email, err := verifyLink(cfg.Secret, resetPurpose, cfg.MaxAge, token)
+	if err == nil {
+		err = consumeToken(token, cfg.MaxAge)
+	}
+	if err != nil {
+		handleError(res, req, msgCompleteFailed, err)
+		return
+	}
+
+	hash, err := HashPassword(password)
+	if err == nil {
+		err = cfg.Store.SetPasswordHash(email, hash)
+	}
+	if err != nil {
+		handleError(res, req, msgCompleteFailed, err)
+		return
+	}
+	respond(res, req, cfg.DonePage, nil)
+}
+
+// addFormRoute registers the handler for POST, form values in the url would end up in access logs,
+// so routers without POST are rejected
+func addFormRoute(r Router, pattern string, handler http.HandlerFunc) error {
+	pr, ok := r.(PostRouter)
+	if !ok {
+		return fmt.Errorf("route %s accepts forms, the router must implement PostRouter", pattern)
+	}
+	pr.Post(pattern, singleCookies(handler))
+	return nil
+}
+
+const (
+	csrfCookie = "login_csrf"
+	csrfField  = "csrf_token"
+)
+
+// CSRFToken returns the token for the "csrf_token" field of password forms, so other sites
+// can't post the forms, e.g. to sign the user in to the attacker's account. The token is kept
+// in a cookie, as there is no session before the login
+func CSRFToken(res http.ResponseWriter, req *http.Request) string {
+	if c, err := req.Cookie(csrfCookie); err == nil && len(c.Value) >= 32 {
+		return c.Value
+	}
+	b := make([]byte, 32)
+	randomBytes(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(res, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(absoluteURL(req, "/"), "https:"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token
+}
+
+// checkCSRF compares the token of the form with the cookie
+func checkCSRF(req *http.Request) error {
+	c, err := req.Cookie(csrfCookie)
+	token := req.PostFormValue(csrfField)
+	if err != nil || token == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(token)) != 1 {
+		return fmt.Errorf("%w: csrf token of the form doesn't match", ErrStateMismatch)
+	}
+	return nil
+}
+
+var dummyHash []byte
+var dummyHashOnce sync.Once
+
+// checkPassword compares the password with the stored hash, unknown users take
+// the same time as known ones
+func checkPassword(passwords PasswordStore, email, password string) error {
+	hash, err := passwords.PasswordHash(email)
+	if err != nil {
+		return fmt.Errorf("can't load password hash: %w", err)
+	}
+	if hash == nil {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = HashPassword("dummy password")
+		})
+		verifyPassword(dummyHash, password)
+		return ErrBadCredentials
+	}
+
+	if email == "" || password == "" || !verifyPassword(hash, password) {
+		return ErrBadCredentials
+	}
+	return nil
+}
+
+// verifyPassword supports bcrypt hashes and argon2id hashes in form of
+// $argon2id$v=19$m=65536,t=3,p=2$salt$hash
+func verifyPassword(hash []byte, password string) bool {
+	if !strings.HasPrefix(string(hash), "$argon2id$") {
+		return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+	}
+
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return false
+	}
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false
+	}
+
+	other := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
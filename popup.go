@@ -0,0 +1,99 @@
+package login
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+const popupKey = "login:popup"
+
+var popupEnabled bool
+var popupOrigins []string
+
+// SetPopupLogin enables login in a popup window: when the login url has "popup" parameter,
+// the result of the login is posted to the opener window, which keeps its state, and the popup
+// closes itself. The result is posted to the origin of the site with popup=1, other origins
+// must be listed and passed in the parameter, e.g. popup=https://app.example.com
+func SetPopupLogin(origins ...string) {
+	popupEnabled = true
+	popupOrigins = origins
+}
+
+// PopupInfo is passed to the popup page template
+type PopupInfo struct {
+	Origin  string
+	Result  map[string]interface{}
+	Message string
+	Nonce   string
+	T       Messages
+}
+
+var popupPage = template.Must(template.New("popup").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.T.sign_in}}</title></head>
+<body>
+<p>{{.Message}}</p>
+<script nonce="{{.Nonce}}">
+(function() {
+	var result = {{.Result}};
+	if (window.opener) {
+		window.opener.postMessage(result, {{.Origin}});
+		window.close();
+	} else if (result.redirect) {
+		window.location.replace(result.redirect);
+	}
+})();
+</script>
+</body>
+</html>`))
+
+// SetPopupPage defines template of the page which posts the result to the opener window
+func SetPopupPage(tmpl *template.Template) {
+	popupPage = tmpl
+}
+
+// savePopup remembers the origin of the opener when the login is started in a popup
+func savePopup(res http.ResponseWriter, req *http.Request) error {
+	value := req.URL.Query().Get("popup")
+	if !popupEnabled || value == "" {
+		return nil
+	}
+
+	origin := strings.TrimSuffix(absoluteURL(req, "/"), "/")
+	if value != "1" && value != "true" {
+		origin = ""
+		for _, o := range popupOrigins {
+			if o == value {
+				origin = o
+			}
+		}
+		if origin == "" {
+			debugf(req, "popup origin %s is not allowed", value)
+			return nil
+		}
+	}
+	return loadSession(req).PutString(res, popupKey, origin)
+}
+
+// renderPopup posts the result to the opener when the login was started in a popup,
+// it returns false for other logins
+func renderPopup(res http.ResponseWriter, req *http.Request, status int, message string, result map[string]interface{}) bool {
+	if !popupEnabled {
+		return false
+	}
+	session := loadSession(req)
+	origin, _ := session.GetString(popupKey)
+	if origin == "" {
+		return false
+	}
+	if err := session.Remove(res, popupKey); err != nil {
+		reportError(req, err)
+	}
+
+	res.Header().Set("Cache-Control", "no-store")
+	renderPage(res, status, popupPage, func(nonce string) interface{} {
+		return PopupInfo{Origin: origin, Result: result, Message: message, Nonce: nonce, T: messagesFor(req)}
+	})
+	return true
+}
@@ -0,0 +1,54 @@
+package login
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+// ProfileConfig describes response of the profile route
+type ProfileConfig struct {
+	// Level returns access level of the user, e.g. "admin", it is omitted when nil
+	Level func(req *http.Request, user goth.User) string
+	// Lifetime of the session set at scs.Manager, expiry is reported when it is set
+	Lifetime time.Duration
+}
+
+// SetProfileRoute adds route which returns the user of the session as JSON, so single page
+// applications can get the state of authentication with one call. Name and avatar are filled
+// when they are stored by SetUserFields. Requests without a user receive 401
+func SetProfileRoute(r Router, url string, cfg ProfileConfig) {
+	addRoute(r, url, labeled("profile", recoverer(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Cache-Control", "no-store")
+
+		user := GetUser(req)
+		if user.Email == "" {
+			writeJSON(res, http.StatusUnauthorized, map[string]interface{}{
+				"status": "unauthorized",
+				"login":  loginRoute,
+			})
+			return
+		}
+
+		body := map[string]interface{}{
+			"status": "ok",
+			"user": map[string]string{
+				"email":    user.Email,
+				"name":     user.Name,
+				"avatar":   user.AvatarURL,
+				"provider": user.Provider,
+			},
+		}
+		if cfg.Level != nil {
+			body["level"] = cfg.Level(req, user)
+		}
+		if loginTime, err := loadSession(req).GetTime(timeKey); err == nil && !loginTime.IsZero() {
+			body["login_time"] = loginTime.UTC().Format(time.RFC3339)
+			if cfg.Lifetime > 0 {
+				body["expires_at"] = loginTime.Add(cfg.Lifetime).UTC().Format(time.RFC3339)
+			}
+		}
+		writeJSON(res, http.StatusOK, body)
+	})))
+}
@@ -0,0 +1,71 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// refreshCall is a token refresh in progress, concurrent callers wait for its result
+type refreshCall struct {
+	wg    sync.WaitGroup
+	token *oauth2.Token
+	err   error
+}
+
+var refreshes = map[string]*refreshCall{}
+var refreshesLock sync.Mutex
+
+// RefreshToken gets new access token of the user from the provider. Concurrent refreshes
+// with the same key (e.g. email of the user) are sent to the provider once and share the result,
+// as providers may invalidate tokens issued by a parallel refresh
+func RefreshToken(providerName, key, refreshToken string) (*oauth2.Token, error) {
+	return RefreshTokenContext(context.Background(), providerName, key, refreshToken)
+}
+
+// contextRefresher is a provider which cancels the refresh with the context, e.g. OIDCProvider
+type contextRefresher interface {
+	RefreshTokenContext(ctx context.Context, refreshToken string) (*oauth2.Token, error)
+}
+
+// RefreshTokenContext is RefreshToken, providers which accept a context cancel the refresh with ctx.
+// Concurrent callers share the refresh started with the context of the first one
+func RefreshTokenContext(ctx context.Context, providerName, key, refreshToken string) (*oauth2.Token, error) {
+	provider, err := getProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+	if !provider.RefreshTokenAvailable() {
+		return nil, errors.New("provider " + providerName + " doesn't support token refresh")
+	}
+
+	key = providerName + ":" + key
+	refreshesLock.Lock()
+	if call, ok := refreshes[key]; ok {
+		refreshesLock.Unlock()
+		call.wg.Wait()
+		return call.token, call.err
+	}
+	// the error is kept for waiters if the refresh doesn't return
+	call := &refreshCall{err: errors.New("token refresh of " + providerName + " is aborted")}
+	call.wg.Add(1)
+	refreshes[key] = call
+	refreshesLock.Unlock()
+
+	// waiters are released even when the provider panics
+	defer func() {
+		refreshesLock.Lock()
+		delete(refreshes, key)
+		refreshesLock.Unlock()
+		call.wg.Done()
+	}()
+
+	if p, ok := provider.(contextRefresher); ok {
+		call.token, call.err = p.RefreshTokenContext(ctx, refreshToken)
+	} else {
+		call.token, call.err = provider.RefreshToken(refreshToken)
+	}
+	return call.token, call.err
+}
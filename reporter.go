@@ -0,0 +1,44 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// ErrorReporter receives unexpected failures of the flow, like token exchange
+// or session store errors and panics, it can be used to forward them to Sentry
+type ErrorReporter interface {
+	Report(req *http.Request, err error)
+}
+
+var reporter ErrorReporter
+
+// SetErrorReporter defines error reporter
+func SetErrorReporter(r ErrorReporter) {
+	reporter = r
+}
+
+func reportError(req *http.Request, err error) {
+	if reporter != nil && err != nil {
+		reporter.Report(req, err)
+	}
+}
+
+// recoverer reports panics of the handler and responds with the error page
+func recoverer(handler http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		defer func() {
+			if p := recover(); p != nil {
+				err, ok := p.(error)
+				if !ok {
+					err = fmt.Errorf("panic: %v", p)
+				}
+
+				reportError(req, err)
+				renderError(res, req, http.StatusInternalServerError, msgAuthFailed, err)
+			}
+		}()
+
+		handler(res, req)
+	}
+}
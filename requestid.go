@@ -0,0 +1,50 @@
+package login
+
+import (
+	"context"
+	"net/http"
+)
+
+var requestID func(req *http.Request) string
+
+// SetRequestID defines a function which extracts id of the request,
+// the id is included in log lines and events
+func SetRequestID(extractor func(req *http.Request) string) {
+	requestID = extractor
+}
+
+// RequestIDFromHeader extracts request id from the header
+func RequestIDFromHeader(name string) func(req *http.Request) string {
+	return func(req *http.Request) string {
+		return req.Header.Get(name)
+	}
+}
+
+// RequestIDFromContext extracts request id stored in request context under the key
+func RequestIDFromContext(key interface{}) func(req *http.Request) string {
+	return func(req *http.Request) string {
+		return contextString(req.Context(), key)
+	}
+}
+
+func contextString(ctx context.Context, key interface{}) string {
+	if value, ok := ctx.Value(key).(string); ok {
+		return value
+	}
+	return ""
+}
+
+func getRequestID(req *http.Request) string {
+	if requestID == nil || req == nil {
+		return ""
+	}
+	return requestID(req)
+}
+
+// logPrefix returns prefix for log lines related to the request
+func logPrefix(req *http.Request) string {
+	if id := getRequestID(req); id != "" {
+		return "[" + id + "] "
+	}
+	return ""
+}
@@ -0,0 +1,50 @@
+package login
+
+import "net/http"
+
+// ProviderResolver returns name of the provider for the request, or empty string
+type ProviderResolver func(req *http.Request) string
+
+// FixedProvider always resolves to the named provider
+func FixedProvider(name string) ProviderResolver {
+	return func(req *http.Request) string {
+		return name
+	}
+}
+
+// ProviderFromQuery resolves provider from the query parameter
+func ProviderFromQuery(param string) ProviderResolver {
+	return func(req *http.Request) string {
+		return req.URL.Query().Get(param)
+	}
+}
+
+// ProviderFromHeader resolves provider from the request header
+func ProviderFromHeader(name string) ProviderResolver {
+	return func(req *http.Request) string {
+		return req.Header.Get(name)
+	}
+}
+
+// ProviderFromURLParam resolves provider from the route parameter, e.g.
+// ProviderFromURLParam(chi.URLParam, "provider")
+func ProviderFromURLParam(param func(req *http.Request, key string) string, key string) ProviderResolver {
+	return func(req *http.Request) string {
+		return param(req, key)
+	}
+}
+
+// FirstProvider uses the first resolver which returns name of a configured provider
+func FirstProvider(resolvers ...ProviderResolver) ProviderResolver {
+	return func(req *http.Request) string {
+		for _, resolve := range resolvers {
+			name := resolve(req)
+			for _, enabled := range enabledProviders {
+				if name == enabled {
+					return name
+				}
+			}
+		}
+		return ""
+	}
+}
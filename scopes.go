@@ -0,0 +1,183 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/markbates/goth"
+)
+
+// session keys of granted scopes, pending scopes are requested and not confirmed by the callback yet
+const (
+	scopesKey        = "login:scopes"
+	pendingScopesKey = "login:pending_scopes"
+)
+
+// GrantedScopes returns scopes granted by the user of the session, they include scopes
+// of the provider configuration and ones added by RequestScopes
+func GrantedScopes(req *http.Request) []string {
+	raw, err := loadSession(req).GetString(scopesKey)
+	if err != nil || raw == "" {
+		return nil
+	}
+	var scopes []string
+	if err := json.Unmarshal([]byte(raw), &scopes); err != nil {
+		logger.Errorf("%sCan't read granted scopes, %s", logPrefix(req), err.Error())
+		return nil
+	}
+	return scopes
+}
+
+// HasScopes checks that all scopes are granted by the user of the session
+func HasScopes(req *http.Request, scopes ...string) bool {
+	granted := GrantedScopes(req)
+	for _, s := range scopes {
+		if !containsScope(granted, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// ScopesURL starts authorization of additional scopes, like GetAuthURL. Google is asked to keep
+// previously granted scopes, so the new token covers all of them
+func ScopesURL(res http.ResponseWriter, req *http.Request, providerName string, scopes ...string) (string, error) {
+	authURL, err := GetAuthURL(res, req, providerName)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+
+	merged := mergeScopes(strings.Fields(u.Query().Get("scope")), GrantedScopes(req), scopes)
+	q := u.Query()
+	q.Set("scope", strings.Join(merged, " "))
+	q.Set("include_granted_scopes", "true")
+	u.RawQuery = q.Encode()
+
+	raw, _ := json.Marshal(scopes)
+	if err := loadSession(req).PutString(res, pendingScopesKey, string(raw)); err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// RequestScopes redirects the user to the provider to grant additional scopes,
+// the user is returned to "returnTo" parameter of the request after the callback
+func RequestScopes(res http.ResponseWriter, req *http.Request, providerName string, scopes ...string) {
+	if err := saveReturnTo(res, req); err != nil {
+		reportError(req, err)
+	}
+	url, err := ScopesURL(res, req, providerName, scopes...)
+	if err != nil {
+		handleError(res, req, msgStartFailed, err)
+		return
+	}
+	http.Redirect(res, req, url, http.StatusTemporaryRedirect)
+}
+
+// RequireScopes wraps the handler, so it is available to authenticated users who granted the scopes.
+// Others are sent to the provider to grant them and returned back, api calls receive 403
+func RequireScopes(next http.Handler, providerName string, scopes ...string) http.Handler {
+	return RequireAuthenticated(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if HasScopes(req, scopes...) {
+			next.ServeHTTP(res, req)
+			return
+		}
+		authMetrics.observeDenial("scopes", "forbidden")
+		if wantsJSON(req) {
+			writeJSON(res, http.StatusForbidden, map[string]interface{}{
+				"status": "insufficient_scope",
+				"scopes": scopes,
+			})
+			return
+		}
+
+		back := req.Clone(req.Context())
+		q := back.URL.Query()
+		q.Set("returnTo", req.URL.RequestURI())
+		back.URL.RawQuery = q.Encode()
+		RequestScopes(res, back, providerName, scopes...)
+	}))
+}
+
+// googleScopes are full names of short scopes, Google reports granted scopes by full names
+var googleScopes = map[string]string{
+	"email":   "https://www.googleapis.com/auth/userinfo.email",
+	"profile": "https://www.googleapis.com/auth/userinfo.profile",
+}
+
+// saveScopes keeps scopes granted at login in the session, scopes granted incrementally
+// by the same user are merged with the earlier ones. When the token response reports
+// granted scopes, scopes unchecked by the user are dropped, and requested scopes are kept
+// only when they are reported
+func saveScopes(res http.ResponseWriter, req *http.Request, user goth.User) error {
+	session := loadSession(req)
+	scopes := providerScopes[user.Provider]
+	reported, ok := user.RawData["scope"].(string)
+	granted := strings.Fields(reported)
+	if ok {
+		scopes = grantedOnly(scopes, granted)
+	}
+
+	if raw, _ := session.GetString(pendingScopesKey); raw != "" {
+		var pending []string
+		if err := json.Unmarshal([]byte(raw), &pending); err == nil {
+			pending = grantedOnly(pending, granted)
+			if previous, _ := session.GetString(emailKey); normalizeEmail(previous) == normalizeEmail(user.Email) {
+				earlier := GrantedScopes(req)
+				if ok {
+					earlier = grantedOnly(earlier, granted)
+				}
+				scopes = mergeScopes(scopes, earlier, pending)
+			} else {
+				scopes = mergeScopes(scopes, pending)
+			}
+		}
+		if err := session.Remove(res, pendingScopesKey); err != nil {
+			return err
+		}
+	}
+
+	if len(scopes) == 0 {
+		return session.Remove(res, scopesKey)
+	}
+	raw, _ := json.Marshal(scopes)
+	return session.PutString(res, scopesKey, string(raw))
+}
+
+// grantedOnly returns scopes which are in the granted list
+func grantedOnly(scopes, granted []string) []string {
+	var result []string
+	for _, s := range scopes {
+		if containsScope(granted, s) || (googleScopes[s] != "" && containsScope(granted, googleScopes[s])) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func mergeScopes(lists ...[]string) []string {
+	var merged []string
+	for _, list := range lists {
+		for _, s := range list {
+			if !containsScope(merged, s) {
+				merged = append(merged, s)
+			}
+		}
+	}
+	return merged
+}
+
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
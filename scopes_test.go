@@ -0,0 +1,64 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/alexedwards/scs"
+	"github.com/alexedwards/scs/stores/memstore"
+	"github.com/markbates/goth"
+)
+
+// withCookies returns request with the last cookie of each name set by the response
+func withCookies(rec *httptest.ResponseRecorder) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	last := map[string]*http.Cookie{}
+	for _, c := range rec.Result().Cookies() {
+		last[c.Name] = c
+	}
+	for _, c := range last {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+// loginWithPending signs the user in after the request of pending scopes, and returns scopes of the session
+func loginWithPending(t *testing.T, user goth.User, pending string) []string {
+	rec := httptest.NewRecorder()
+	if err := loadSession(httptest.NewRequest("GET", "/", nil)).PutString(rec, pendingScopesKey, pending); err != nil {
+		t.Fatal(err)
+	}
+	next := httptest.NewRecorder()
+	if err := SignIn(next, withCookies(rec), user); err != nil {
+		t.Fatal(err)
+	}
+	return GrantedScopes(withCookies(next))
+}
+
+func TestSaveScopesGranted(t *testing.T) {
+	previous, previousScopes := store, providerScopes["google"]
+	SetSession(scs.NewManager(memstore.New(0)))
+	providerScopes["google"] = []string{"email", "profile"}
+	defer func() {
+		SetSession(previous)
+		providerScopes["google"] = previousScopes
+	}()
+
+	// profile and calendar are unchecked on the consent screen
+	user := goth.User{Email: "user@example.com", Provider: "google", RawData: map[string]interface{}{
+		"scope": "openid https://www.googleapis.com/auth/userinfo.email https://www.googleapis.com/auth/drive",
+	}}
+	scopes := loginWithPending(t, user, `["https://www.googleapis.com/auth/drive","https://www.googleapis.com/auth/calendar"]`)
+	if expected := []string{"email", "https://www.googleapis.com/auth/drive"}; !reflect.DeepEqual(scopes, expected) {
+		t.Errorf("granted scopes are %v, expected %v", scopes, expected)
+	}
+
+	// without the report of the provider requested scopes aren't recorded
+	user.RawData = nil
+	scopes = loginWithPending(t, user, `["https://www.googleapis.com/auth/drive"]`)
+	if expected := []string{"email", "profile"}; !reflect.DeepEqual(scopes, expected) {
+		t.Errorf("granted scopes are %v, expected %v", scopes, expected)
+	}
+}
@@ -0,0 +1,250 @@
+package login
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alexedwards/scs"
+)
+
+// sessionValueKey is the value of the scs session which keeps all keys of the package,
+// so the session is saved once however many keys a response changes
+const sessionValueKey = "login"
+
+const sessionContextKey contextKey = "login-session"
+
+// loginSession keeps keys of the package in one value of the scs session. Routes of the package
+// commit changes once per response, elsewhere each change is written at once
+type loginSession struct {
+	mu      sync.Mutex
+	session *scs.Session
+	values  map[string]string
+	err     error
+	staged  bool
+	dirty   bool
+	renew   bool
+}
+
+// sessionHolder is added to requests of the routes, the session is loaded on first use
+type sessionHolder struct {
+	session   *loginSession
+	committed bool
+}
+
+// loadSession returns the session of the request, a tenant can have its own session manager
+func loadSession(req *http.Request) *loginSession {
+	h, ok := req.Context().Value(sessionContextKey).(*sessionHolder)
+	if !ok {
+		return openSession(req, false)
+	}
+	if h.session == nil {
+		h.session = openSession(req, !h.committed)
+	}
+	return h.session
+}
+
+func openSession(req *http.Request, staged bool) *loginSession {
+	s := &loginSession{session: sessionManager(req).Load(req), staged: staged, values: map[string]string{}}
+	raw, err := s.session.GetString(sessionValueKey)
+	if err != nil {
+		s.err = err
+		return s
+	}
+	if raw != "" {
+		if err := json.Unmarshal([]byte(raw), &s.values); err != nil {
+			// a corrupted value is dropped, like a missing one
+			logger.Errorf("%sCan't read user's session, %s", logPrefix(req), err.Error())
+			s.values = map[string]string{}
+		}
+	}
+	return s
+}
+
+// withSession returns the request whose session changes are kept until commitSession,
+// the request of a route is returned as is, its session is committed with the response
+func withSession(req *http.Request) (*http.Request, bool) {
+	if _, ok := req.Context().Value(sessionContextKey).(*sessionHolder); ok {
+		return req, false
+	}
+	return withSessionHolder(req), true
+}
+
+func withSessionHolder(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), sessionContextKey, &sessionHolder{}))
+}
+
+// commitSession saves changes of the session of the request, once per response they are
+// written by routes and after withSession
+func commitSession(res http.ResponseWriter, req *http.Request) error {
+	h, ok := req.Context().Value(sessionContextKey).(*sessionHolder)
+	if !ok {
+		return nil
+	}
+	h.committed = true
+	if h.session == nil {
+		return nil
+	}
+	return h.session.commit(res)
+}
+
+func (s *loginSession) commit(w http.ResponseWriter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// changes made after the commit, e.g. while writing the body, are written at once
+	s.staged = false
+	return s.write(w)
+}
+
+func (s *loginSession) write(w http.ResponseWriter) error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.renew {
+		if err := s.session.RenewToken(w); err != nil {
+			return fmt.Errorf("can't renew session token: %w", err)
+		}
+		s.renew = false
+	}
+	if !s.dirty {
+		return nil
+	}
+	s.dirty = false
+	if len(s.values) == 0 {
+		return s.session.Remove(w, sessionValueKey)
+	}
+	raw, err := json.Marshal(s.values)
+	if err != nil {
+		return err
+	}
+	return s.session.PutString(w, sessionValueKey, string(raw))
+}
+
+func (s *loginSession) set(w http.ResponseWriter, key string, value string, exists bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+
+	previous, found := s.values[key]
+	if exists {
+		if found && previous == value {
+			return nil
+		}
+		s.values[key] = value
+	} else {
+		if !found {
+			return nil
+		}
+		delete(s.values, key)
+	}
+	s.dirty = true
+	if s.staged {
+		return nil
+	}
+	return s.write(w)
+}
+
+func (s *loginSession) get(key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return "", false, s.err
+	}
+	value, ok := s.values[key]
+	return value, ok, nil
+}
+
+// RenewToken replaces token of the session, so a token known before the login
+// or logout is of no use afterwards
+func (s *loginSession) RenewToken(w http.ResponseWriter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.renew = true
+	if s.staged {
+		return nil
+	}
+	return s.write(w)
+}
+
+// Keys returns the sorted keys of the session
+func (s *loginSession) Keys() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	keys := make([]string, 0, len(s.values))
+	for k := range s.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *loginSession) GetString(key string) (string, error) {
+	value, _, err := s.get(key)
+	return value, err
+}
+
+func (s *loginSession) PutString(w http.ResponseWriter, key string, value string) error {
+	return s.set(w, key, value, true)
+}
+
+func (s *loginSession) PopString(w http.ResponseWriter, key string) (string, error) {
+	value, _, err := s.get(key)
+	if err != nil {
+		return "", err
+	}
+	return value, s.Remove(w, key)
+}
+
+func (s *loginSession) Remove(w http.ResponseWriter, key string) error {
+	return s.set(w, key, "", false)
+}
+
+func (s *loginSession) GetTime(key string) (time.Time, error) {
+	value, ok, err := s.get(key)
+	if err != nil || !ok {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+func (s *loginSession) PutTime(w http.ResponseWriter, key string, value time.Time) error {
+	return s.set(w, key, value.Format(time.RFC3339Nano), true)
+}
+
+func (s *loginSession) GetBool(key string) (bool, error) {
+	value, ok, err := s.get(key)
+	if err != nil || !ok {
+		return false, err
+	}
+	return strconv.ParseBool(value)
+}
+
+func (s *loginSession) PutBool(w http.ResponseWriter, key string, value bool) error {
+	return s.set(w, key, strconv.FormatBool(value), true)
+}
+
+func (s *loginSession) GetBytes(key string) ([]byte, error) {
+	value, ok, err := s.get(key)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(value)
+}
+
+func (s *loginSession) PutBytes(w http.ResponseWriter, key string, value []byte) error {
+	return s.set(w, key, base64.StdEncoding.EncodeToString(value), true)
+}
@@ -0,0 +1,140 @@
+// Package shardstore is an in-memory session store for the SCS session package,
+// the data is split between lock-striped shards, so concurrent requests rarely wait for each other.
+//
+// Like memstore, all session data is lost when the program is stopped, use it for single-binary
+// deployments where this is acceptable.
+package shardstore
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	data   []byte
+	expiry time.Time
+}
+
+type shard struct {
+	sync.RWMutex
+	entries map[string]entry
+}
+
+// ShardStore keeps sessions in memory, expired sessions are removed by the background cleanup
+type ShardStore struct {
+	shards []*shard
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// New returns store with the count of shards, 0 uses 32 shards.
+// The cleanupInterval controls how often expired sessions are removed, 0 disables the cleanup,
+// expired sessions are never returned by Find anyway
+func New(shards int, cleanupInterval time.Duration) *ShardStore {
+	if shards <= 0 {
+		shards = 32
+	}
+
+	s := &ShardStore{shards: make([]*shard, shards), stop: make(chan struct{})}
+	for i := range s.shards {
+		s.shards[i] = &shard{entries: make(map[string]entry)}
+	}
+	if cleanupInterval > 0 {
+		go s.cleanup(cleanupInterval)
+	}
+	return s
+}
+
+// Find returns data of the session token, expired and missing tokens are not found
+func (s *ShardStore) Find(token string) ([]byte, bool, error) {
+	sh := s.shard(token)
+	sh.RLock()
+	e, ok := sh.entries[token]
+	sh.RUnlock()
+
+	if !ok || !time.Now().Before(e.expiry) {
+		return nil, false, nil
+	}
+	return e.data, true, nil
+}
+
+// Save adds or replaces data of the session token
+func (s *ShardStore) Save(token string, b []byte, expiry time.Time) error {
+	sh := s.shard(token)
+	sh.Lock()
+	sh.entries[token] = entry{data: b, expiry: expiry}
+	sh.Unlock()
+	return nil
+}
+
+// Add saves data of the token only when it is missing or expired, it reports whether the data was saved
+func (s *ShardStore) Add(token string, b []byte, expiry time.Time) (bool, error) {
+	sh := s.shard(token)
+	sh.Lock()
+	defer sh.Unlock()
+	if e, ok := sh.entries[token]; ok && time.Now().Before(e.expiry) {
+		return false, nil
+	}
+	sh.entries[token] = entry{data: b, expiry: expiry}
+	return true, nil
+}
+
+// Delete removes the session token
+func (s *ShardStore) Delete(token string) error {
+	sh := s.shard(token)
+	sh.Lock()
+	delete(sh.entries, token)
+	sh.Unlock()
+	return nil
+}
+
+// Len returns count of stored sessions, including expired ones not removed yet
+func (s *ShardStore) Len() int {
+	n := 0
+	for _, sh := range s.shards {
+		sh.RLock()
+		n += len(sh.entries)
+		sh.RUnlock()
+	}
+	return n
+}
+
+// StopCleanup terminates the background cleanup
+func (s *ShardStore) StopCleanup() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+func (s *ShardStore) shard(token string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(token))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+func (s *ShardStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.deleteExpired()
+		}
+	}
+}
+
+// deleteExpired locks one shard at a time, so the cleanup doesn't stop the whole store
+func (s *ShardStore) deleteExpired() {
+	now := time.Now()
+	for _, sh := range s.shards {
+		sh.Lock()
+		for token, e := range sh.entries {
+			if !now.Before(e.expiry) {
+				delete(sh.entries, token)
+			}
+		}
+		sh.Unlock()
+	}
+}
@@ -0,0 +1,63 @@
+package shardstore_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs"
+	"github.com/mkozhukh/login"
+	"github.com/mkozhukh/login/authtest"
+	"github.com/mkozhukh/login/shardstore"
+)
+
+func TestStore(t *testing.T) {
+	authtest.TestStore(t, func() scs.Store { return shardstore.New(4, 0) })
+}
+
+func TestAdd(t *testing.T) {
+	var s login.AddStore = shardstore.New(4, 0)
+
+	if added, err := s.Add("token", []byte("first"), time.Now().Add(time.Minute)); !added || err != nil {
+		t.Fatalf("missing token must be added, got %v %v", added, err)
+	}
+	if added, _ := s.Add("token", []byte("second"), time.Now().Add(time.Minute)); added {
+		t.Error("existing token must not be replaced")
+	}
+	if added, _ := s.Add("expired", []byte("first"), time.Now().Add(-time.Second)); !added {
+		t.Fatal("missing token must be added")
+	}
+	if added, _ := s.Add("expired", []byte("second"), time.Now().Add(time.Minute)); !added {
+		t.Error("expired token must be replaced")
+	}
+
+	// one of concurrent callers consumes the token
+	var wins int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if added, _ := s.Add("state", []byte{1}, time.Now().Add(time.Minute)); added {
+				atomic.AddInt32(&wins, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if wins != 1 {
+		t.Errorf("token is added %d times", wins)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	s := shardstore.New(4, 10*time.Millisecond)
+	defer s.StopCleanup()
+
+	_ = s.Save("expired", []byte("value"), time.Now().Add(time.Millisecond))
+	_ = s.Save("valid", []byte("value"), time.Now().Add(time.Minute))
+	time.Sleep(100 * time.Millisecond)
+	if n := s.Len(); n != 1 {
+		t.Errorf("expired token must be removed, %d tokens left", n)
+	}
+}
@@ -0,0 +1,332 @@
+package login
+
+import (
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/alexedwards/scs"
+	"github.com/alexedwards/scs/stores/memstore"
+)
+
+const (
+	returnToKey = "login:return_to"
+	stateCookie = "login_state"
+)
+
+var stateSecret []byte
+var stateMaxAge = 10 * time.Minute
+var clockSkew = time.Minute
+
+// clock returns current time of state and session timestamps
+var clock = time.Now
+
+var stateValidator func(req *http.Request, state string) error
+
+var usedStates scs.Store = memstore.New(time.Minute)
+var usedStatesLock sync.Mutex
+
+// SetStateSecret enables stateless state parameter, signed with HMAC-SHA256.
+// Signed state carries its creation time and optional "returnTo" value, so callback
+// can be validated without the session cookie created before redirect to the provider
+func SetStateSecret(secret []byte, maxAge time.Duration) {
+	stateSecret = secret
+	if maxAge > 0 {
+		stateMaxAge = maxAge
+	}
+}
+
+// SetStateFunc replaces generator of the state parameter sent to the provider,
+// custom state can carry application data, e.g. a tenant. Nil restores the default generator
+func SetStateFunc(fn func(req *http.Request) string) {
+	if fn == nil {
+		fn = defaultState
+	}
+	setState = fn
+}
+
+// SetValidateStateFunc defines a function which checks the state returned to the callback,
+// in addition to the built-in checks. Returned error rejects the callback with ErrStateMismatch
+func SetValidateStateFunc(fn func(req *http.Request, state string) error) {
+	stateValidator = fn
+}
+
+// SetClockSkew defines allowed leeway for validation of token timestamps,
+// so instances with slightly drifted clocks accept each other's tokens
+func SetClockSkew(leeway time.Duration) {
+	clockSkew = leeway
+}
+
+// SetClock replaces source of current time used for state tokens, login time of sessions, windows
+// of metrics, cached access checks and webhook events, so expiry can be tested deterministically.
+// Nil restores the system clock
+func SetClock(now func() time.Time) {
+	if now == nil {
+		now = time.Now
+	}
+	clock = now
+}
+
+var randSource *rand.Rand
+var randSourceLock sync.Mutex
+
+// SetRandSource replaces source of random state nonces, e.g. with rand.NewSource(1) in tests.
+// Nonces come from crypto/rand by default, nil restores it
+func SetRandSource(src rand.Source) {
+	randSourceLock.Lock()
+	defer randSourceLock.Unlock()
+	if src == nil {
+		randSource = nil
+		return
+	}
+	randSource = rand.New(src)
+}
+
+// randomBytes fills b with random bytes of the nonce source
+func randomBytes(b []byte) {
+	randSourceLock.Lock()
+	if randSource != nil {
+		defer randSourceLock.Unlock()
+		randSource.Read(b)
+		return
+	}
+	randSourceLock.Unlock()
+
+	if _, err := cryptorand.Read(b); err != nil {
+		// the state must not be predictable
+		panic(fmt.Sprintf("can't generate nonce: %s", err.Error()))
+	}
+}
+
+// checkTimestamps validates issue, not before and expiry times of a token, allowing for clock skew,
+// zero times are not checked
+func checkTimestamps(issued, notBefore, expires time.Time) error {
+	now := clock()
+	if !issued.IsZero() && issued.After(now.Add(clockSkew)) {
+		return errors.New("token used before issued")
+	}
+	if !notBefore.IsZero() && notBefore.After(now.Add(clockSkew)) {
+		return errors.New("token used before its validity period")
+	}
+	if !expires.IsZero() && now.After(expires.Add(clockSkew)) {
+		return errors.New("token expired")
+	}
+	return nil
+}
+
+// AddStore is implemented by stores which save a key only when it is missing, e.g. with Redis SET NX,
+// so concurrent instances can't consume one token twice
+type AddStore interface {
+	Add(key string, b []byte, expiry time.Time) (bool, error)
+}
+
+// SetUsedStateStore defines storage for state tokens consumed by callbacks, revoked sessions,
+// login attempts counted by the captcha and refreshed provider tokens.
+// The default one is in-memory, use a shared store when running several instances
+func SetUsedStateStore(s scs.Store) {
+	usedStates = s
+}
+
+// SetMultiInstance prepares the flow for several instances behind a load balancer:
+// state is signed with the secret, so the callback can be served by any instance,
+// and consumed state tokens, revoked sessions, login attempts and refreshed tokens are kept
+// in the shared store. Sessions must use a shared store too, e.g. the cookie store or the same Redis
+func SetMultiInstance(shared scs.Store, stateSecret []byte) {
+	SetStateSecret(stateSecret, 0)
+	SetUsedStateStore(shared)
+}
+
+// consumeState marks state as used, it returns an error if the state was already used
+func consumeState(state string) error {
+	return consumeToken(state, stateMaxAge)
+}
+
+// consumeToken marks a single-use token as used for its lifetime
+func consumeToken(state string, maxAge time.Duration) error {
+	if state == "" {
+		return nil
+	}
+
+	sum := sha256.Sum256([]byte(state))
+	key := "login-state:" + hex.EncodeToString(sum[:])
+	expiry := clock().Add(maxAge + clockSkew)
+
+	if store, ok := usedStates.(AddStore); ok {
+		added, err := store.Add(key, []byte{1}, expiry)
+		if err != nil {
+			return fmt.Errorf("can't check used states: %w", err)
+		}
+		if !added {
+			return ErrCallbackReused
+		}
+		return nil
+	}
+
+	// other stores are checked and written under the lock, it covers one instance only
+	usedStatesLock.Lock()
+	defer usedStatesLock.Unlock()
+
+	_, found, err := usedStates.Find(key)
+	if err != nil {
+		return fmt.Errorf("can't check used states: %w", err)
+	}
+	if found {
+		return ErrCallbackReused
+	}
+
+	return usedStates.Save(key, []byte{1}, expiry)
+}
+
+// ReturnTo returns the local path from "returnTo" parameter of the login url,
+// it is carried by signed state or saved in the session
+func ReturnTo(req *http.Request) string {
+	if stateSecret != nil {
+		if returnTo, err := verifyState(getState(req)); err == nil && returnTo != "" {
+			return returnTo
+		}
+	}
+
+	returnTo, err := loadSession(req).GetString(returnToKey)
+	if err != nil {
+		return ""
+	}
+	return returnTo
+}
+
+// LoginURL returns url of the login route, which returns the user to the current page after login
+func LoginURL(loginURL string, req *http.Request) string {
+	sep := "?"
+	if strings.Contains(loginURL, "?") {
+		sep = "&"
+	}
+	return loginURL + sep + "returnTo=" + url.QueryEscape(req.URL.RequestURI())
+}
+
+// saveReturnTo keeps "returnTo" parameter of the login request in the session,
+// with signed state it is carried by the state instead
+func saveReturnTo(res http.ResponseWriter, req *http.Request) error {
+	returnTo := localPath(req.URL.Query().Get("returnTo"))
+	if returnTo == "" || stateSecret != nil {
+		return nil
+	}
+	return loadSession(req).PutString(res, returnToKey, returnTo)
+}
+
+// localPath allows only relative paths of the current site, to prevent open redirects.
+// Browsers drop whitespace and control characters of Location, e.g. "/\t/evil.com" becomes
+// "//evil.com", so such paths are rejected
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return ""
+	}
+	for _, r := range path {
+		if unicode.IsControl(r) || unicode.IsSpace(r) {
+			return ""
+		}
+	}
+	u, err := url.Parse(path)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return ""
+	}
+	return path
+}
+
+// signState creates state in form of base64(time|nonce|returnTo).base64(hmac)
+func signState(returnTo string) string {
+	payload := make([]byte, 8+16, 8+16+len(returnTo))
+	binary.BigEndian.PutUint64(payload, uint64(clock().Unix()))
+	randomBytes(payload[8:])
+	payload = append(payload, returnTo...)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(stateMAC(payload))
+}
+
+// verifyState checks signature and age of the state, and returns carried "returnTo" value
+func verifyState(state string) (string, error) {
+	parts := strings.SplitN(state, ".", 2)
+	if len(parts) != 2 {
+		return "", ErrStateMismatch
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(payload) < 8+16 {
+		return "", ErrStateMismatch
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(mac, stateMAC(payload)) {
+		return "", ErrStateMismatch
+	}
+
+	created := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if err := checkTimestamps(created, time.Time{}, created.Add(stateMaxAge)); err != nil {
+		return "", ErrStateExpired
+	}
+
+	return string(payload[8+16:]), nil
+}
+
+// stateNonce returns nonce of the signed state, or empty string
+func stateNonce(state string) string {
+	payload, err := base64.RawURLEncoding.DecodeString(strings.SplitN(state, ".", 2)[0])
+	if err != nil || len(payload) < 8+16 {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(payload[8 : 8+16])
+}
+
+// bindState keeps nonce of the signed state in a short-lived cookie, so the callback accepts
+// the state only from the browser which started the login. Lax cookies are sent with
+// the redirect from the provider, unlike the session cookie of SameSite=Strict setups
+func bindState(res http.ResponseWriter, req *http.Request, state string) {
+	nonce := stateNonce(state)
+	if nonce == "" {
+		return
+	}
+	http.SetCookie(res, &http.Cookie{
+		Name:     stateCookie,
+		Value:    nonce,
+		Path:     "/",
+		MaxAge:   int((stateMaxAge + clockSkew).Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(absoluteURL(req, "/"), "https:"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// checkStateBinding rejects signed state issued to another browser, e.g. the callback url
+// of an attacker's login handed to the victim
+func checkStateBinding(req *http.Request, state string) error {
+	c, err := req.Cookie(stateCookie)
+	nonce := stateNonce(state)
+	if err != nil || nonce == "" || !hmac.Equal([]byte(c.Value), []byte(nonce)) {
+		return fmt.Errorf("%w: state is not issued to the browser", ErrStateMismatch)
+	}
+	return nil
+}
+
+// clearStateBinding removes the cookie of the signed state after the callback
+func clearStateBinding(res http.ResponseWriter, req *http.Request) {
+	if _, err := req.Cookie(stateCookie); err != nil {
+		return
+	}
+	http.SetCookie(res, &http.Cookie{Name: stateCookie, Path: "/", MaxAge: -1})
+}
+
+func stateMAC(payload []byte) []byte {
+	h := hmac.New(sha256.New, stateSecret)
+	h.Write(payload)
+	return h.Sum(nil)
+}
@@ -0,0 +1,157 @@
+package login
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+const (
+	sudoPendingKey   = "login:sudo_pending"
+	sudoRequestedKey = "login:sudo_requested"
+	sudoUntilKey     = "login:sudo_until"
+	// sudoPendingAge limits time of the re-authentication after the sudo route
+	sudoPendingAge = 10 * time.Minute
+)
+
+// SudoConfig describes temporary elevation of access level
+type SudoConfig struct {
+	// From is the level which can elevate, e.g. "editor"
+	From string
+	// To is the level given for the Duration, e.g. "admin"
+	To string
+	// Duration of the elevation, 10 minutes by default
+	Duration time.Duration
+	// Params are added to the url of the provider to force re-authentication, max_age=0 by default
+	Params url.Values
+}
+
+var sudo *SudoConfig
+
+// SetSudo adds the route which elevates users of one level to another for a short time after
+// they re-authenticate, like the sudo prompt of GitHub. The route takes "returnTo" parameter,
+// the elevation is saved in the session and emitted as Elevation event. Level returns
+// the elevated level until the elevation expires, so RequireLevel works as usual.
+// Providers must report "auth_time" in the id token, e.g. OIDCProvider, other logins
+// are elevated only by the password and email link forms
+func SetSudo(r Router, sudoURL string, cfg SudoConfig) {
+	if cfg.Duration <= 0 {
+		cfg.Duration = 10 * time.Minute
+	}
+	if cfg.Params == nil {
+		cfg.Params = url.Values{"max_age": {"0"}}
+	}
+	sudo = &cfg
+
+	addRoute(r, sudoURL, labeled("sudo", recoverer(func(res http.ResponseWriter, req *http.Request) {
+		user := GetUser(req)
+		if user.Email == "" {
+			redirect(res, LoginURL(loginRoute, req))
+			return
+		}
+		if savedLevel(req) != cfg.From {
+			renderDenied(res, req, user.Email)
+			return
+		}
+
+		session := loadSession(req)
+		err := session.PutString(res, sudoPendingKey, user.Email)
+		if err == nil {
+			err = session.PutTime(res, sudoRequestedKey, clock())
+		}
+		if err != nil {
+			handleError(res, req, msgStartFailed, err)
+			return
+		}
+		if err := saveReturnTo(res, req); err != nil {
+			reportError(req, err)
+		}
+		if _, err := getProvider(user.Provider); err != nil {
+			// password and email link logins re-authenticate with their forms
+			target, ok := retryPages[user.Provider]
+			if !ok {
+				target = loginRoute
+			}
+			respond(res, req, target, nil)
+			return
+		}
+		params := url.Values{"login_hint": {user.Email}}
+		for key, values := range cfg.Params {
+			params[key] = values
+		}
+		BeginAuthHandler(res, WithAuthParams(req, params), user.Provider)
+	})))
+}
+
+// SudoUntil returns end of the elevation of the session user, or zero time
+func SudoUntil(req *http.Request) time.Time {
+	if sudo == nil {
+		return time.Time{}
+	}
+	until, err := loadSession(req).GetTime(sudoUntilKey)
+	if err != nil || !clock().Before(until) {
+		return time.Time{}
+	}
+	return until
+}
+
+// saveElevation elevates the user who re-authenticated after the sudo route,
+// any other login drops the elevation
+func saveElevation(res http.ResponseWriter, req *http.Request, user goth.User) error {
+	session := loadSession(req)
+	if sudo == nil {
+		return session.Remove(res, sudoUntilKey)
+	}
+
+	pending, err := session.PopString(res, sudoPendingKey)
+	if err != nil {
+		return err
+	}
+	requested, err := session.GetTime(sudoRequestedKey)
+	if err != nil {
+		return err
+	}
+	if err := session.Remove(res, sudoRequestedKey); err != nil {
+		return err
+	}
+	if pending == "" || normalizeEmail(pending) != normalizeEmail(user.Email) || claimMapping.Level(user) != sudo.From {
+		return session.Remove(res, sudoUntilKey)
+	}
+	if clock().Sub(requested) > sudoPendingAge {
+		debugf(req, "sudo: request of %s has expired", user.Email)
+		return session.Remove(res, sudoUntilKey)
+	}
+	// the provider can ignore max_age, the login must be later than the sudo request
+	if at := authTime(user); at.IsZero() || at.Add(clockSkew).Before(requested) {
+		debugf(req, "sudo: %s didn't re-authenticate at %s", user.Email, user.Provider)
+		return session.Remove(res, sudoUntilKey)
+	}
+
+	if err := session.PutTime(res, sudoUntilKey, clock().Add(sudo.Duration)); err != nil {
+		return err
+	}
+	emitEvent(req, Elevation, user.Provider, user, nil)
+	return nil
+}
+
+// authTime returns when the user authenticated, forms of the package check credentials at once,
+// providers report it as "auth_time" of the id token. Zero time is returned when it is unknown
+func authTime(user goth.User) time.Time {
+	if user.Provider == passwordProvider || user.Provider == magicProvider {
+		return clock()
+	}
+	// the token was verified by the provider when the user was fetched
+	token, _ := user.RawData["id_token"].(string)
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	var claims IDClaims
+	if err := decodeSegment(parts[1], &claims); err != nil || claims.AuthTime == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.AuthTime, 0)
+}
@@ -0,0 +1,195 @@
+package login
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/alexedwards/scs"
+	"github.com/markbates/goth"
+)
+
+const tenantKey = "login:tenant"
+
+// Tenant is an organization served in isolation from others
+type Tenant struct {
+	Name string
+	// Hosts of the tenant, e.g. "acme.example.com", or PathPrefix, e.g. "/acme"
+	Hosts      []string
+	PathPrefix string
+	// Provider with credentials of the tenant, its callback url must point to the tenant
+	Provider goth.Provider
+	// Allow checks users of the tenant, nil leaves the decision to Handler.Login
+	Allow func(email string) bool
+	// Session with own cookie of the tenant, e.g. with Path of the prefix, nil uses the default one.
+	// Sessions are isolated anyway, a session of one tenant is not accepted by another
+	Session *scs.Manager
+}
+
+var tenants []Tenant
+
+// tenantBases keeps names of tenant providers before the prefix of the tenant,
+// so repeated SetTenants doesn't prefix them again
+var tenantBases = map[goth.Provider]string{}
+var tenantHookOnce sync.Once
+
+// SetTenants enables multi-tenant mode: tenant of each request is selected by host or path prefix,
+// providers of tenants are added, and users logged in at one tenant are unknown to others.
+// Use TenantProvider as resolver of the routes, and TenantSessions if tenants have own sessions
+func SetTenants(list ...Tenant) error {
+	// the list and hosts of the caller are left unchanged
+	list = append([]Tenant(nil), list...)
+
+	names := map[string]bool{}
+	for i, t := range list {
+		if t.Name == "" {
+			return errors.New("tenant name is empty")
+		}
+		if names[t.Name] {
+			return fmt.Errorf("tenant %q is defined twice", t.Name)
+		}
+		names[t.Name] = true
+		if len(t.Hosts) == 0 && t.PathPrefix == "" {
+			return fmt.Errorf("tenant %q has neither hosts nor path prefix", t.Name)
+		}
+		if t.Provider == nil {
+			return fmt.Errorf("tenant %q has no provider", t.Name)
+		}
+		list[i].Hosts = make([]string, len(t.Hosts))
+		for j, host := range t.Hosts {
+			list[i].Hosts[j] = strings.ToLower(host)
+		}
+	}
+
+	// providers of the previous list are replaced by the new one
+	for _, t := range tenants {
+		removeProvider(t.Provider.Name())
+	}
+	for _, t := range list {
+		base, ok := tenantBases[t.Provider]
+		if !ok {
+			base = t.Provider.Name()
+			tenantBases[t.Provider] = base
+		}
+		// providers of tenants are of the same kind, names keep them apart
+		t.Provider.SetName(t.Name + ":" + base)
+		AddProvider(t.Provider)
+	}
+	tenants = list
+
+	tenantHookOnce.Do(func() { BeforeLogin(allowTenant) })
+	return nil
+}
+
+// allowTenant is the BeforeLogin hook which checks users with Allow of the current tenants
+func allowTenant(e Event) error {
+	t, ok := tenantByName(e.Tenant)
+	if ok && t.Allow != nil && !t.Allow(e.Email) {
+		return fmt.Errorf("user is not in the list of tenant %s", t.Name)
+	}
+	return nil
+}
+
+func removeProvider(name string) {
+	delete(providers, name)
+	for i, enabled := range enabledProviders {
+		if enabled == name {
+			enabledProviders = append(enabledProviders[:i:i], enabledProviders[i+1:]...)
+			break
+		}
+	}
+}
+
+// CurrentTenant returns name of the tenant of the request, or empty string
+func CurrentTenant(req *http.Request) string {
+	if t, ok := tenantOf(req); ok {
+		return t.Name
+	}
+	return ""
+}
+
+// TenantProvider resolves provider of the tenant of the request
+func TenantProvider() ProviderResolver {
+	return func(req *http.Request) string {
+		if t, ok := tenantOf(req); ok {
+			return t.Provider.Name()
+		}
+		return ""
+	}
+}
+
+// TenantSessions wraps the application, so sessions of tenants are loaded once per request,
+// like scs.Manager.Use does for the default session
+func TenantSessions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		sessionManager(req).Use(next).ServeHTTP(res, req)
+	})
+}
+
+// sessionManager returns session manager of the tenant, or the default one
+func sessionManager(req *http.Request) *scs.Manager {
+	if t, ok := tenantOf(req); ok && t.Session != nil {
+		return t.Session
+	}
+	return store
+}
+
+func tenantOf(req *http.Request) (Tenant, bool) {
+	if len(tenants) == 0 {
+		return Tenant{}, false
+	}
+
+	host := strings.ToLower(req.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, t := range tenants {
+		for _, h := range t.Hosts {
+			if h == host {
+				return t, true
+			}
+		}
+	}
+
+	var found Tenant
+	for _, t := range tenants {
+		if t.PathPrefix == "" || len(t.PathPrefix) <= len(found.PathPrefix) {
+			continue
+		}
+		prefix := strings.TrimSuffix(t.PathPrefix, "/")
+		if req.URL.Path == prefix || strings.HasPrefix(req.URL.Path, prefix+"/") {
+			found = t
+		}
+	}
+	return found, found.Name != ""
+}
+
+func tenantByName(name string) (Tenant, bool) {
+	for _, t := range tenants {
+		if t.Name == name && name != "" {
+			return t, true
+		}
+	}
+	return Tenant{}, false
+}
+
+// saveTenant binds the session to the tenant of the request
+func saveTenant(res http.ResponseWriter, req *http.Request) error {
+	session := loadSession(req)
+	if len(tenants) == 0 {
+		return session.Remove(res, tenantKey)
+	}
+	return session.PutString(res, tenantKey, CurrentTenant(req))
+}
+
+// sameTenant checks that the session was established at the tenant of the request
+func sameTenant(req *http.Request) bool {
+	if len(tenants) == 0 {
+		return true
+	}
+	name, _ := loadSession(req).GetString(tenantKey)
+	return name == CurrentTenant(req)
+}
@@ -0,0 +1,73 @@
+package login
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/google"
+)
+
+func TestSetTenantsKeepsList(t *testing.T) {
+	previous := enabledProviders
+	defer func() {
+		tenants, enabledProviders = nil, previous
+		delete(providers, "acme:google")
+	}()
+
+	hosts := []string{"ACME.example.com"}
+	list := []Tenant{{
+		Name:     "acme",
+		Hosts:    hosts,
+		Provider: google.New("key", "secret", "https://acme.example.com/callback", "email"),
+	}}
+	if err := SetTenants(list...); err != nil {
+		t.Fatal(err)
+	}
+
+	if hosts[0] != "ACME.example.com" || list[0].Hosts[0] != "ACME.example.com" {
+		t.Errorf("hosts of the caller are changed to %v", hosts)
+	}
+	list[0].Name = "changed"
+	if name := CurrentTenant(httptest.NewRequest("GET", "http://Acme.Example.com/", nil)); name != "acme" {
+		t.Errorf("tenant of the host is %q", name)
+	}
+}
+
+func TestSetTenantsTwice(t *testing.T) {
+	previous := enabledProviders
+	provider := google.New("key", "secret", "https://acme.example.com/callback", "email")
+	defer func() {
+		tenants, enabledProviders = nil, previous
+		delete(providers, "acme:google")
+		delete(tenantBases, provider)
+	}()
+
+	allow := func(email string) func(string) bool {
+		return func(e string) bool { return e == email }
+	}
+	if err := SetTenants(Tenant{Name: "acme", Hosts: []string{"acme.example.com"}, Provider: provider, Allow: allow("alice@example.com")}); err != nil {
+		t.Fatal(err)
+	}
+	hooks := len(vetoHooks)
+	if err := SetTenants(Tenant{Name: "acme", Hosts: []string{"acme.example.com"}, Provider: provider, Allow: allow("bob@example.com")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if provider.Name() != "acme:google" || len(vetoHooks) != hooks {
+		t.Errorf("second call renames the provider to %s and adds %d hooks", provider.Name(), len(vetoHooks)-hooks)
+	}
+	for _, name := range enabledProviders {
+		if strings.HasPrefix(name, "acme:acme:") {
+			t.Errorf("provider %s is enabled", name)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "http://acme.example.com/callback", nil)
+	for email, allowed := range map[string]bool{"alice@example.com": false, "bob@example.com": true} {
+		if ok := approveLogin(httptest.NewRecorder(), req, provider.Name(), goth.User{Email: email}); ok != allowed {
+			t.Errorf("login of %s is approved=%t", email, ok)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package login
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+const pendingTermsKey = "login:pending_terms"
+
+// TermsStore keeps versions of the terms accepted by users
+type TermsStore interface {
+	// AcceptedTerms returns version accepted by the user, or an empty string
+	AcceptedTerms(email string) (string, error)
+	AcceptTerms(email, version string) error
+}
+
+// TermsConfig describes terms which users must accept before the session is established
+type TermsConfig struct {
+	// Version of the current terms, users who accepted another version are asked again
+	Version string
+	// URL of the terms text, it is linked from the acceptance page
+	URL   string
+	Store TermsStore
+}
+
+// TermsInfo is passed to the terms page template
+type TermsInfo struct {
+	TermsURL  string
+	AcceptURL string
+	Token     string
+	CSRFToken string
+	Nonce     string
+	T         Messages
+}
+
+var termsPage = template.Must(template.New("terms").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.T.terms_title}}</title></head>
+<body>
+<h1>{{.T.terms_title}}</h1>
+<p>{{.T.terms_text}}</p>
+{{if .TermsURL}}<p><a href="{{.TermsURL}}" target="_blank">{{.T.terms_link}}</a></p>{{end}}
+<form method="post" action="{{.AcceptURL}}">
+<input type="hidden" name="accept" value="{{.Token}}">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<button type="submit">{{.T.terms_accept}}</button>
+</form>
+</body>
+</html>`))
+
+// SetTermsPage defines template of the page where users accept the terms
+func SetTermsPage(tmpl *template.Template) {
+	termsPage = tmpl
+}
+
+// pendingLogin is the login waiting for acceptance of the terms, the user itself
+// with the provider tokens is kept on the server, in the store of used states
+type pendingLogin struct {
+	Token    string    `json:"token"`
+	ReturnTo string    `json:"return_to"`
+	Created  time.Time `json:"created"`
+}
+
+// terms of SetTerms, nil when acceptance isn't required
+var terms *TermsConfig
+var termsURL string
+
+// SetTerms adds the acceptance step after authentication: users who haven't accepted
+// the current version of the terms are shown the page of acceptURL, and the session
+// is established only after they accept with the POST form, so the router must implement PostRouter.
+// BeforeLogin hooks run before the step, and the step runs before the gateway, whatever gateway is set
+func SetTerms(r Router, acceptURL string, cfg TermsConfig, handler Handler) error {
+	if cfg.Store == nil {
+		return fmt.Errorf("terms store is not set")
+	}
+	if cfg.Version == "" {
+		return fmt.Errorf("terms version is empty")
+	}
+
+	err := addFormRoute(r, acceptURL, labeled("terms", recoverer(func(res http.ResponseWriter, req *http.Request) {
+		if err := checkCSRF(req); err != nil {
+			handleError(res, req, msgCompleteFailed, err)
+			return
+		}
+		pending, err := loadPendingLogin(req)
+		if err != nil {
+			handleProviderError(res, req, "", err)
+			return
+		}
+		token := req.PostFormValue("accept")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(pending.Token)) != 1 {
+			handleError(res, req, msgCompleteFailed, ErrStateMismatch)
+			return
+		}
+
+		session := loadSession(req)
+		if err := session.Remove(res, pendingTermsKey); err != nil {
+			reportError(req, err)
+		}
+		user, err := takePendingUser(pending.Token)
+		if err != nil {
+			handleProviderError(res, req, "", err)
+			return
+		}
+		if err := cfg.Store.AcceptTerms(user.Email, cfg.Version); err != nil {
+			handleError(res, req, msgCompleteFailed, fmt.Errorf("can't save accepted terms: %w", err))
+			return
+		}
+		debugf(req, "terms %s accepted by %s", cfg.Version, user.Email)
+
+		// signed state of the callback is gone, the page is returned through the session
+		if pending.ReturnTo != "" {
+			if err := session.PutString(res, returnToKey, pending.ReturnTo); err != nil {
+				reportError(req, err)
+			}
+		}
+		continueLogin(res, req, user, handler)
+	})))
+	if err != nil {
+		return err
+	}
+
+	addRoute(r, acceptURL, labeled("terms", recoverer(func(res http.ResponseWriter, req *http.Request) {
+		pending, err := loadPendingLogin(req)
+		if err != nil {
+			handleProviderError(res, req, "", err)
+			return
+		}
+
+		csrf := CSRFToken(res, req)
+		if jsonMode || wantsJSON(req) {
+			writeJSON(res, http.StatusOK, map[string]interface{}{
+				"status":     "accept_terms",
+				"terms":      cfg.URL,
+				"accept":     acceptURL,
+				"token":      pending.Token,
+				"csrf_token": csrf,
+			})
+			return
+		}
+		renderPage(res, http.StatusOK, termsPage, func(nonce string) interface{} {
+			return TermsInfo{TermsURL: cfg.URL, AcceptURL: acceptURL, Token: pending.Token, CSRFToken: csrf, Nonce: nonce, T: messagesFor(req)}
+		})
+	})))
+	terms, termsURL = &cfg, acceptURL
+	return nil
+}
+
+// checkTerms sends the user who hasn't accepted the current terms to the acceptance page
+func checkTerms(res http.ResponseWriter, req *http.Request, user goth.User) bool {
+	if terms == nil {
+		return true
+	}
+	accepted, err := terms.Store.AcceptedTerms(user.Email)
+	if err != nil {
+		handleError(res, req, msgCompleteFailed, fmt.Errorf("can't check accepted terms: %w", err))
+		return false
+	}
+	if accepted == terms.Version {
+		return true
+	}
+
+	pending := pendingLogin{Token: newNonce(), ReturnTo: ReturnTo(req), Created: clock()}
+	if err := savePendingUser(pending, user); err != nil {
+		handleError(res, req, msgCompleteFailed, err)
+		return false
+	}
+	if err := savePendingLogin(res, req, pending); err != nil {
+		handleError(res, req, msgCompleteFailed, err)
+		return false
+	}
+	respond(res, req, termsURL, nil)
+	return false
+}
+
+func savePendingLogin(res http.ResponseWriter, req *http.Request, pending pendingLogin) error {
+	raw, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	return storeInSession(pendingTermsKey, string(raw), req, res)
+}
+
+// loadPendingLogin returns the user waiting for acceptance, it is valid as long as the state
+func loadPendingLogin(req *http.Request) (pendingLogin, error) {
+	var pending pendingLogin
+	raw, err := getFromSession(pendingTermsKey, req)
+	if err != nil {
+		return pending, err
+	}
+	if err := json.Unmarshal([]byte(raw), &pending); err != nil {
+		return pending, fmt.Errorf("%w: %s", ErrSessionMissing, err.Error())
+	}
+	if clock().After(pending.Created.Add(stateMaxAge)) {
+		return pending, fmt.Errorf("%w: terms were not accepted in time", ErrSessionMissing)
+	}
+	return pending, nil
+}
+
+func pendingUserKey(token string) string {
+	return "login-terms:" + token
+}
+
+func savePendingUser(pending pendingLogin, user goth.User) error {
+	raw, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return usedStates.Save(pendingUserKey(pending.Token), raw, pending.Created.Add(stateMaxAge+clockSkew))
+}
+
+// takePendingUser returns the user waiting for acceptance, each login is accepted once
+func takePendingUser(token string) (goth.User, error) {
+	var user goth.User
+	usedStatesLock.Lock()
+	defer usedStatesLock.Unlock()
+
+	raw, found, err := usedStates.Find(pendingUserKey(token))
+	if err != nil {
+		return user, err
+	}
+	if !found {
+		return user, fmt.Errorf("%w: terms were not accepted in time", ErrSessionMissing)
+	}
+	if err := usedStates.Delete(pendingUserKey(token)); err != nil {
+		return user, err
+	}
+	if err := json.Unmarshal(raw, &user); err != nil {
+		return user, fmt.Errorf("%w: %s", ErrSessionMissing, err.Error())
+	}
+	return user, nil
+}
@@ -0,0 +1,101 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/alexedwards/scs"
+	"github.com/alexedwards/scs/stores/memstore"
+	"github.com/markbates/goth"
+)
+
+type formRouter struct{ *http.ServeMux }
+
+func (r formRouter) Get(pattern string, fn http.HandlerFunc)  { r.HandleFunc("GET "+pattern, fn) }
+func (r formRouter) Post(pattern string, fn http.HandlerFunc) { r.HandleFunc("POST "+pattern, fn) }
+
+type termsStore map[string]string
+
+func (s termsStore) AcceptedTerms(email string) (string, error) { return s[email], nil }
+func (s termsStore) AcceptTerms(email, version string) error {
+	s[email] = version
+	return nil
+}
+
+// cookieJar keeps the last cookie of each name between requests of the test
+type cookieJar map[string]*http.Cookie
+
+func (j cookieJar) request(req *http.Request, rec *httptest.ResponseRecorder) *http.Request {
+	if rec != nil {
+		for _, c := range rec.Result().Cookies() {
+			j[c.Name] = c
+		}
+	}
+	for _, c := range j {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func TestTermsAcceptance(t *testing.T) {
+	previous := store
+	SetSession(scs.NewManager(memstore.New(0)))
+	accepted := termsStore{}
+	r := formRouter{http.NewServeMux()}
+	if err := SetTerms(r, "/terms/accept", TermsConfig{Version: "v2", Store: accepted}, acceptHandler("alice@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		SetSession(previous)
+		terms, termsURL = nil, ""
+	}()
+
+	jar := cookieJar{}
+	rec := httptest.NewRecorder()
+	user := goth.User{Email: "alice@example.com", Provider: "google", AccessToken: "access-secret"}
+	completeLogin(rec, httptest.NewRequest("GET", "/callback", nil), "google", user, acceptHandler("alice@example.com"))
+	if location := rec.Header().Get("Location"); location != "/terms/accept" {
+		t.Fatalf("login is redirected to %q", location)
+	}
+
+	// tokens of the provider stay on the server
+	raw, err := getFromSession(pendingTermsKey, jar.request(httptest.NewRequest("GET", "/", nil), rec))
+	if err != nil || strings.Contains(raw, "access-secret") || strings.Contains(raw, "alice") {
+		t.Errorf("session keeps %q, %v", raw, err)
+	}
+
+	page := httptest.NewRecorder()
+	get := jar.request(httptest.NewRequest("GET", "/terms/accept", nil), nil)
+	get.Header.Set("Accept", "application/json")
+	r.ServeHTTP(page, get)
+	var info struct {
+		Token string `json:"token"`
+		CSRF  string `json:"csrf_token"`
+	}
+	if err := json.Unmarshal(page.Body.Bytes(), &info); err != nil || info.Token == "" || info.CSRF == "" {
+		t.Fatalf("terms page responded with %s", page.Body.String())
+	}
+
+	// neither GET with the token nor POST without the csrf token accepts the terms
+	r.ServeHTTP(httptest.NewRecorder(), jar.request(httptest.NewRequest("GET", "/terms/accept?accept="+info.Token, nil), page))
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := jar.request(httptest.NewRequest("POST", "/terms/accept", strings.NewReader(form.Encode())), nil)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+	post(url.Values{"accept": {info.Token}})
+	if accepted["alice@example.com"] != "" {
+		t.Fatal("terms are accepted without the form")
+	}
+
+	rec = post(url.Values{"accept": {info.Token}, "csrf_token": {info.CSRF}})
+	if accepted["alice@example.com"] != "v2" || rec.Header().Get("Location") != "/app" {
+		t.Errorf("terms are accepted as %q, redirected to %q", accepted["alice@example.com"], rec.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,155 @@
+package login
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/markbates/goth"
+	"golang.org/x/oauth2"
+)
+
+const tokenKey = "login:token"
+
+// ErrNoToken is returned when the session has no provider token, see SetKeepTokens
+var ErrNoToken = errors.New("no provider token in the session")
+
+var keepTokens = false
+
+// SetKeepTokens enables saving of the provider tokens in the session at login, they are used
+// by GoogleClient. Tokens give access to the account of the user, use a server-side store
+// or an encrypted cookie store with this option
+func SetKeepTokens(keep bool) {
+	keepTokens = keep
+}
+
+// SessionToken returns the provider token saved in the session
+func SessionToken(req *http.Request) (*oauth2.Token, error) {
+	raw, err := loadSession(req).GetString(tokenKey)
+	if err != nil {
+		return nil, err
+	}
+	if raw == "" {
+		return nil, ErrNoToken
+	}
+	token := &oauth2.Token{}
+	if err := json.Unmarshal([]byte(raw), token); err != nil {
+		return nil, fmt.Errorf("can't read token of the session: %w", err)
+	}
+	if latest := refreshedToken(token.RefreshToken); latest != nil {
+		return latest, nil
+	}
+	return token, nil
+}
+
+// GoogleClient returns http client which calls Google apis on behalf of the user of the session,
+// e.g. Calendar or Drive. Expired access token is refreshed when the provider issued
+// a refresh token. The client of SetHTTPClient is used for transport
+func GoogleClient(ctx context.Context, req *http.Request) (*http.Client, error) {
+	token, err := SessionToken(req)
+	if err != nil {
+		return nil, err
+	}
+	provider, _ := loadSession(req).GetString(providerKey)
+	if provider == "" {
+		return nil, ErrNoToken
+	}
+
+	if httpClient != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+	}
+	source := &sessionTokenSource{ctx: ctx, provider: provider, email: GetEmail(req), token: token}
+	return oauth2.NewClient(ctx, oauth2.ReuseTokenSource(token, source)), nil
+}
+
+// sessionTokenSource refreshes the token of the session
+type sessionTokenSource struct {
+	ctx      context.Context
+	provider string
+	email    string
+	token    *oauth2.Token
+}
+
+func (s *sessionTokenSource) Token() (*oauth2.Token, error) {
+	if s.token.RefreshToken == "" {
+		return nil, fmt.Errorf("%w: access token expired and there is no refresh token", ErrNoToken)
+	}
+
+	token, err := RefreshTokenContext(s.ctx, s.provider, s.email, s.token.RefreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("can't refresh token: %w", err)
+	}
+	// Google doesn't return the refresh token again
+	if token.RefreshToken == "" {
+		token.RefreshToken = s.token.RefreshToken
+	}
+	rememberToken(s.token.RefreshToken, token)
+	return token, nil
+}
+
+// refreshed tokens can't be written to the session without the response, they are kept
+// in the store of used states until expiry, so requests to any instance don't refresh them again
+func rememberToken(refreshToken string, token *oauth2.Token) {
+	raw, err := json.Marshal(token)
+	if err == nil {
+		err = usedStates.Save(refreshedKey(refreshToken), raw, token.Expiry)
+	}
+	if err != nil {
+		logger.Errorf("Can't save refreshed token, %s", err.Error())
+	}
+}
+
+func refreshedToken(refreshToken string) *oauth2.Token {
+	if refreshToken == "" {
+		return nil
+	}
+
+	raw, found, err := usedStates.Find(refreshedKey(refreshToken))
+	if err != nil || !found {
+		return nil
+	}
+	token := &oauth2.Token{}
+	if err := json.Unmarshal(raw, token); err != nil || !token.Valid() {
+		return nil
+	}
+	return token
+}
+
+// refreshedKey doesn't keep the refresh token itself in the store
+func refreshedKey(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return "login-token:" + hex.EncodeToString(sum[:])
+}
+
+// saveToken keeps tokens of the user in the session, refresh token of the same user
+// is preserved when the provider doesn't send it again, e.g. after incremental authorization
+func saveToken(res http.ResponseWriter, req *http.Request, user goth.User) error {
+	session := loadSession(req)
+	if !keepTokens || user.AccessToken == "" {
+		return session.Remove(res, tokenKey)
+	}
+
+	token := &oauth2.Token{
+		AccessToken:  user.AccessToken,
+		RefreshToken: user.RefreshToken,
+		Expiry:       user.ExpiresAt,
+		TokenType:    "Bearer",
+	}
+	if token.RefreshToken == "" {
+		if previous, err := SessionToken(req); err == nil {
+			if email, _ := session.GetString(emailKey); normalizeEmail(email) == normalizeEmail(user.Email) {
+				token.RefreshToken = previous.RefreshToken
+			}
+		}
+	}
+
+	raw, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return session.PutString(res, tokenKey, string(raw))
+}
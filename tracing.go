@@ -0,0 +1,66 @@
+package login
+
+import (
+	"context"
+	"net/http"
+)
+
+// Tracer starts spans for steps of the authentication flow,
+// it can be implemented on top of OpenTelemetry or any other tracing library,
+// package otellogin has the adapter of OpenTelemetry
+type Tracer interface {
+	Start(ctx context.Context, name string, provider string) (context.Context, Span)
+}
+
+// Span is a single traced step, End receives the result of the step
+type Span interface {
+	End(err error)
+}
+
+// AttributeSpan is a span which records attributes of the step: "auth.tenant" of the request
+// and "auth.outcome", which is "success", "failure" or, for logins denied by the application, "denied"
+type AttributeSpan interface {
+	Span
+	SetAttribute(key, value string)
+}
+
+var tracer Tracer = noopTracer{}
+
+// SetTracer defines tracer, nil disables tracing
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = noopTracer{}
+	}
+	tracer = t
+}
+
+// startSpan starts the span of the step with the tenant of the request
+func startSpan(req *http.Request, name, provider string) (context.Context, Span) {
+	ctx, span := tracer.Start(req.Context(), name, provider)
+	setSpanAttribute(span, "auth.tenant", CurrentTenant(req))
+	return ctx, span
+}
+
+// endSpan ends the span with the outcome, or with "failure" when there is an error
+func endSpan(span Span, outcome string, err error) {
+	if err != nil {
+		outcome = "failure"
+	}
+	setSpanAttribute(span, "auth.outcome", outcome)
+	span.End(err)
+}
+
+func setSpanAttribute(span Span, key, value string) {
+	if s, ok := span.(AttributeSpan); ok && value != "" {
+		s.SetAttribute(key, value)
+	}
+}
+
+type noopTracer struct{}
+type noopSpan struct{}
+
+func (noopTracer) Start(ctx context.Context, name string, provider string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noopSpan) End(err error) {}
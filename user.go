@@ -0,0 +1,251 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/markbates/goth"
+)
+
+// session keys of the authenticated user
+const (
+	emailKey    = "login:email"
+	providerKey = "login:provider"
+	userKey     = "login:user"
+	timeKey     = "login:time"
+)
+
+// user fields which can be stored in the session
+var userFields = map[string]func(u *goth.User) *string{
+	"name":         func(u *goth.User) *string { return &u.Name },
+	"first_name":   func(u *goth.User) *string { return &u.FirstName },
+	"last_name":    func(u *goth.User) *string { return &u.LastName },
+	"nickname":     func(u *goth.User) *string { return &u.NickName },
+	"avatar":       func(u *goth.User) *string { return &u.AvatarURL },
+	"user_id":      func(u *goth.User) *string { return &u.UserID },
+	"location":     func(u *goth.User) *string { return &u.Location },
+	"access_token": func(u *goth.User) *string { return &u.AccessToken },
+}
+
+var storedFields []string
+
+// UserHandler can be implemented by Handler to receive all data of the user instead of the email
+type UserHandler interface {
+	LoginUser(req *http.Request, res http.ResponseWriter, user goth.User) string
+}
+
+// SetUserFields defines fields of goth.User stored in the session along with the email,
+// "name", "first_name", "last_name", "nickname", "avatar", "user_id", "location" and "access_token"
+// are supported. Stored fields are returned by GetUser
+func SetUserFields(fields ...string) error {
+	for _, f := range fields {
+		if _, ok := userFields[f]; !ok {
+			return fmt.Errorf("unknown user field %q", f)
+		}
+	}
+	storedFields = fields
+	return nil
+}
+
+type contextKey string
+
+const emailContextKey contextKey = "login-email"
+const userContextKey contextKey = "login-user"
+
+// requestUser caches the user of the request, it is resolved on first use
+type requestUser struct {
+	once sync.Once
+	user goth.User
+}
+
+// saveUser stores identity and selected fields of the authenticated user in the session,
+// the changes are saved at once
+func saveUser(res http.ResponseWriter, req *http.Request, user goth.User) error {
+	req, own := withSession(req)
+	if err := writeUser(res, req, user); err != nil {
+		return err
+	}
+	if own {
+		if err := commitSession(res, req); err != nil {
+			return err
+		}
+	}
+	authMetrics.observeSession(user.Provider)
+	return nil
+}
+
+func writeUser(res http.ResponseWriter, req *http.Request, user goth.User) error {
+	session := loadSession(req)
+	// a token known before the login, e.g. planted by an attacker, must not carry the identity
+	if err := session.RenewToken(res); err != nil {
+		return fmt.Errorf("can't save user's session: %w", err)
+	}
+	// scopes and tokens are merged only for the same user, so they go before the email
+	if err := saveScopes(res, req, user); err != nil {
+		return fmt.Errorf("can't save user's session: %w", err)
+	}
+	if err := saveToken(res, req, user); err != nil {
+		return fmt.Errorf("can't save user's session: %w", err)
+	}
+	if err := session.PutString(res, emailKey, user.Email); err != nil {
+		return fmt.Errorf("can't save user's session: %w", err)
+	}
+	if err := session.PutString(res, providerKey, user.Provider); err != nil {
+		return fmt.Errorf("can't save user's session: %w", err)
+	}
+	if err := session.PutTime(res, timeKey, clock()); err != nil {
+		return fmt.Errorf("can't save user's session: %w", err)
+	}
+
+	if err := upgradeGuest(res, req, user); err != nil {
+		return fmt.Errorf("can't save user's session: %w", err)
+	}
+	if err := addAccount(res, req, user); err != nil {
+		return fmt.Errorf("can't save user's session: %w", err)
+	}
+	if err := saveLevel(res, req, user); err != nil {
+		return fmt.Errorf("can't save user's session: %w", err)
+	}
+	if err := saveElevation(res, req, user); err != nil {
+		return fmt.Errorf("can't save user's session: %w", err)
+	}
+	if err := saveTenant(res, req); err != nil {
+		return fmt.Errorf("can't save user's session: %w", err)
+	}
+	if err := saveFeatures(res, req, user); err != nil {
+		return fmt.Errorf("can't save user's session: %w", err)
+	}
+	if err := notifyLogin(res, req, user); err != nil {
+		return fmt.Errorf("can't save user's session: %w", err)
+	}
+
+	if len(storedFields) > 0 {
+		data := make(map[string]string, len(storedFields))
+		for _, f := range storedFields {
+			data[f] = *userFields[f](&user)
+		}
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if err := session.PutString(res, userKey, string(raw)); err != nil {
+			return fmt.Errorf("can't save user's session: %w", err)
+		}
+	}
+	return nil
+}
+
+// SignIn establishes the session of the user without a provider,
+// e.g. for custom flows and tests. Handler and hooks are not called
+func SignIn(res http.ResponseWriter, req *http.Request, user goth.User) error {
+	return saveUser(res, req, user)
+}
+
+// snapshotSession returns keys and login time of the session
+func snapshotSession(req *http.Request) *SessionSnapshot {
+	session := loadSession(req)
+	snapshot := &SessionSnapshot{}
+	snapshot.Keys, _ = session.Keys()
+	snapshot.LoginTime, _ = session.GetTime(timeKey)
+	return snapshot
+}
+
+// GetUser returns the user authenticated in the session of the request, only email, provider
+// and fields defined by SetUserFields are filled. Email is empty when there is no user.
+// Behind Middleware the user is read from the session once per request
+func GetUser(req *http.Request) goth.User {
+	if cached, ok := req.Context().Value(userContextKey).(*requestUser); ok {
+		cached.once.Do(func() { cached.user = loadUser(req) })
+		return cached.user
+	}
+	return loadUser(req)
+}
+
+func loadUser(req *http.Request) goth.User {
+	session := loadSession(req)
+	user := goth.User{Email: GetEmail(req)}
+	if user.Email == "" {
+		return user
+	}
+	user.Provider, _ = session.GetString(providerKey)
+
+	raw, err := session.GetString(userKey)
+	if err != nil || raw == "" {
+		return user
+	}
+	data := map[string]string{}
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		logger.Errorf("%sCan't read user's session, %s", logPrefix(req), err.Error())
+		return user
+	}
+	for f, value := range data {
+		if field, ok := userFields[f]; ok {
+			*field(&user) = value
+		}
+	}
+	return user
+}
+
+// clearUser removes identity of the user from the session and renews its token
+func clearUser(res http.ResponseWriter, req *http.Request) error {
+	session := loadSession(req)
+	if err := removeAccount(res, req); err != nil {
+		return err
+	}
+	keys := []string{emailKey, providerKey, userKey, timeKey, scopesKey, tokenKey, levelKey, tenantKey,
+		featuresKey, sessionIDKey, sudoPendingKey, sudoRequestedKey, sudoUntilKey}
+	for _, key := range keys {
+		if err := session.Remove(res, key); err != nil {
+			return err
+		}
+	}
+	return session.RenewToken(res)
+}
+
+// GetEmail returns email of the user authenticated in the session of the request, or empty string
+func GetEmail(req *http.Request) string {
+	if email, ok := req.Context().Value(emailContextKey).(string); ok {
+		return email
+	}
+
+	email, err := loadSession(req).GetString(emailKey)
+	if err != nil {
+		logger.Errorf("%sCan't read user's session, %s", logPrefix(req), err.Error())
+		return ""
+	}
+	if email != "" && (!sameTenant(req) || sessionRevoked(req)) {
+		return ""
+	}
+	return email
+}
+
+// Middleware adds email of the authenticated user to the request context, users of mobile apps
+// are authenticated by access tokens. The user returned by GetUser is cached for the rest of the request
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if _, ok := req.Context().Value(userContextKey).(*requestUser); ok {
+			// already resolved by an outer middleware
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		ctx := context.WithValue(req.Context(), userContextKey, &requestUser{})
+		email := GetEmail(req)
+		if email == "" {
+			email = AppEmail(req)
+		}
+		if email != "" {
+			ctx = context.WithValue(ctx, emailContextKey, email)
+		}
+		next.ServeHTTP(res, req.WithContext(ctx))
+	})
+}
+
+// EmailFromContext returns email of the authenticated user added by Middleware, or empty string
+func EmailFromContext(ctx context.Context) string {
+	email, _ := ctx.Value(emailContextKey).(string)
+	return email
+}
@@ -0,0 +1,103 @@
+package login
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// SignatureHeader contains hex encoded HMAC-SHA256 of webhook payload
+const SignatureHeader = "X-Login-Signature"
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+var webhookRetries = 5
+
+type webhookPayload struct {
+	Type      EventType `json:"type"`
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// AddWebhook sends login, logout, denial and elevation events as JSON to the url,
+// payload is signed with the secret, delivery is retried with exponential backoff
+func AddWebhook(url string, secret []byte) {
+	hook := func(e Event) {
+		payload := webhookPayload{
+			Type:      e.Type,
+			Provider:  e.Provider,
+			Email:     e.Email,
+			RequestID: e.RequestID,
+			IP:        e.IP,
+			UserAgent: e.UserAgent,
+			Time:      clock().UTC(),
+		}
+		if e.Error != nil {
+			payload.Error = e.Error.Error()
+		}
+
+		body, err := json.Marshal(payload)
+		if err != nil {
+			logger.Errorf("Can't encode webhook payload, %s", err.Error())
+			return
+		}
+
+		go deliverWebhook(url, secret, body)
+	}
+
+	OnLogin(hook)
+	OnLogout(hook)
+	OnDenied(hook)
+	OnElevation(hook)
+}
+
+func deliverWebhook(url string, secret []byte, body []byte) {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		err := postWebhook(url, signature, body)
+		if err == nil {
+			return
+		}
+		if attempt >= webhookRetries {
+			logger.Errorf("Can't deliver webhook to %s, %s", url, err.Error())
+			return
+		}
+
+		logger.Debugf("Webhook to %s failed (attempt %d), %s", url, attempt, err.Error())
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func postWebhook(url, signature string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, signature)
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return nil
+}